@@ -0,0 +1,61 @@
+/*
+Copyright 2021 SiMing Weng.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterSpoditorPolicySpec defines the default per-ordinal rules applied to the pods of the StatefulSets it selects
+// in any namespace
+type ClusterSpoditorPolicySpec struct {
+	// NamespaceSelector of the labels of the namespaces of the targeted StatefulSets, all the namespaces when unset
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Selector of the labels of the targeted StatefulSets, none when unset, all when empty
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Rules are applied in order to the pods whose ordinal they qualify, without replacing anything already set
+	// +optional
+	Rules []PolicyRule `json:"rules,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=csdp
+
+// ClusterSpoditorPolicy is the Schema for the clusterspoditorpolicies API
+type ClusterSpoditorPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ClusterSpoditorPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterSpoditorPolicyList contains a list of ClusterSpoditorPolicy
+type ClusterSpoditorPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterSpoditorPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterSpoditorPolicy{}, &ClusterSpoditorPolicyList{})
+}
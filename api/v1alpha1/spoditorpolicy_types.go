@@ -0,0 +1,105 @@
+/*
+Copyright 2021 SiMing Weng.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SpoditorPolicySpec defines the per-ordinal rules applied to the pods of the targeted StatefulSets
+type SpoditorPolicySpec struct {
+	// Target selects the StatefulSets of the policy namespace
+	Target PolicyTarget `json:"target"`
+
+	// Rules are applied in order to the pods whose ordinal they qualify
+	// +optional
+	Rules []PolicyRule `json:"rules,omitempty"`
+}
+
+// PolicyTarget selects StatefulSets either by name or by labels
+type PolicyTarget struct {
+	// Name of the targeted StatefulSet
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Selector of the labels of the targeted StatefulSets
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// PolicyRule is applied to the pods whose ordinal it qualifies
+type PolicyRule struct {
+	// Ordinals qualified by the rule, with the same syntax as annotation qualifiers, e.g. 0, 2-5, 5- or -5.
+	// Empty for all the pods
+	// +optional
+	Ordinals string `json:"ordinals,omitempty"`
+
+	// Volumes added to the pod, configmap and secret names containing {{ are expanded as name templates
+	// +optional
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+
+	// Containers patched by name
+	// +optional
+	Containers []ContainerRule `json:"containers,omitempty"`
+
+	// Sidecars are containers added to the pod
+	// +optional
+	Sidecars []corev1.Container `json:"sidecars,omitempty"`
+}
+
+// ContainerRule patches the container of the same name
+type ContainerRule struct {
+	// Name of the patched container
+	Name string `json:"name"`
+
+	// VolumeMounts added to the container
+	// +optional
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+
+	// Env added to the container, replacing the variables of the same name
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// Resources overriding the requests and limits of the container, per resource name
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=sdp
+
+// SpoditorPolicy is the Schema for the spoditorpolicies API
+type SpoditorPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SpoditorPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SpoditorPolicyList contains a list of SpoditorPolicy
+type SpoditorPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SpoditorPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SpoditorPolicy{}, &SpoditorPolicyList{})
+}
@@ -1,12 +1,14 @@
 package annotation
 
 import (
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -22,6 +24,11 @@ type Handler interface {
 	GetParser() Parser
 }
 
+// Named is optionally implemented by a Handler to identify itself by the annotation name it claims
+type Named interface {
+	Name() string
+}
+
 type Parser interface {
 	Parse(annotations map[QualifiedName]string) (interface{}, error)
 }
@@ -34,6 +41,12 @@ func (p ParserFunc) Parse(annotations map[QualifiedName]string) (interface{}, er
 
 var _ Parser = ParserFunc(nil)
 
+// QualifiedConfig is optionally implemented by the configuration a parser returns when only some of the annotations
+// of the handler are applied to a pod, telling the qualifiers of those applied to the ordinal
+type QualifiedConfig interface {
+	Qualifiers(ordinal int) []string
+}
+
 type QualifiedName struct {
 	Qualifier string
 	Name      string
@@ -51,31 +64,124 @@ func (c CollectorFunc) Collect(accessor metav1.ObjectMetaAccessor) map[Qualified
 
 var _ QualifiedAnnotationCollector = CollectorFunc(nil)
 
-var Collector QualifiedAnnotationCollector = defaultCollector
-
-var defaultCollector CollectorFunc = func(accessor metav1.ObjectMetaAccessor) map[QualifiedName]string {
-	m := map[QualifiedName]string{}
-	for k, v := range accessor.GetObjectMeta().GetAnnotations() {
-		ll := log.WithValues("key", k, "value", v)
-		if strings.HasPrefix(k, Prefix) {
-			ll.Info("found ss-arg annotation")
-			n := strings.TrimPrefix(k, Prefix)
-			i := strings.LastIndex(n, Separator)
-			if i == -1 {
+var Collector QualifiedAnnotationCollector = NewCollector(DefaultSyntax)
+
+// OrdinalsQualifier introduces the qualifier of the alternative key syntax, e.g. spoditor.io/mount-volume.ordinals-2-5,
+// which is valid under Kubernetes annotation key rules whatever the separator.
+// As a key can't end with -, spoditor.io/mount-volume.ordinals-from-5 stands for the 5- qualifier,
+// and spoditor.io/mount-volume.ordinals-to-5 for the -5 one
+const OrdinalsQualifier = ".ordinals-"
+
+// Syntax of the annotation keys
+type Syntax struct {
+	// Prefixes of the keys, e.g. spoditor.io/. When migrating between domains, the annotation of the first prefix
+	// takes precedence over the same annotation of the next ones
+	Prefixes []string
+	// Separator between the annotation name and its qualifier, e.g. _ in spoditor.io/mount-volume_0-2.
+	// When empty, only the alternative syntax qualifies annotations
+	Separator string
+}
+
+// DefaultSyntax is spoditor.io/<name>[_<qualifier>]
+var DefaultSyntax = Syntax{Prefixes: []string{Prefix}, Separator: Separator}
+
+// Parse splits an annotation key into its qualified name, returning false when the key has none of the prefixes,
+// and the index of the prefix otherwise
+func (s Syntax) Parse(key string) (QualifiedName, int, bool) {
+	for i, p := range s.Prefixes {
+		if !strings.HasPrefix(key, p) {
+			continue
+		}
+		n := strings.TrimPrefix(key, p)
+		if j := strings.LastIndex(n, OrdinalsQualifier); j != -1 {
+			q := n[j+len(OrdinalsQualifier):]
+			if strings.HasPrefix(q, "from-") {
+				q = strings.TrimPrefix(q, "from-") + "-"
+			} else if strings.HasPrefix(q, "to-") {
+				q = "-" + strings.TrimPrefix(q, "to-")
+			}
+			return QualifiedName{Qualifier: q, Name: n[:j]}, i, true
+		}
+		if s.Separator != "" {
+			if j := strings.LastIndex(n, s.Separator); j != -1 {
+				return QualifiedName{Qualifier: n[j+len(s.Separator):], Name: n[:j]}, i, true
+			}
+		}
+		return QualifiedName{Name: n}, i, true
+	}
+	return QualifiedName{}, 0, false
+}
+
+// Key formats the qualified name with the first prefix, and the separator or, without separator, the alternative syntax
+func (s Syntax) Key(k QualifiedName) string {
+	if k.Qualifier == "" {
+		return s.Prefixes[0] + k.Name
+	}
+	if s.Separator != "" {
+		return s.Prefixes[0] + k.Name + s.Separator + k.Qualifier
+	}
+	q := k.Qualifier
+	if strings.HasPrefix(q, "-") {
+		q = "to" + q
+	} else if strings.HasSuffix(q, "-") {
+		q = "from-" + strings.TrimSuffix(q, "-")
+	}
+	return s.Prefixes[0] + k.Name + OrdinalsQualifier + q
+}
+
+// Validate checks the prefixes and that the separator can't be mistaken for part of an annotation name
+func (s Syntax) Validate() error {
+	if len(s.Prefixes) == 0 {
+		return fmt.Errorf("no annotation prefix")
+	}
+	for _, p := range s.Prefixes {
+		if err := ValidatePrefix(p); err != nil {
+			return err
+		}
+	}
+	if strings.Trim(s.Separator, "_.") != "" {
+		return fmt.Errorf("invalid qualifier separator %q, expect _ or . characters", s.Separator)
+	}
+	return nil
+}
+
+// NewCollector collects the annotations of the syntax
+func NewCollector(s Syntax) CollectorFunc {
+	return func(accessor metav1.ObjectMetaAccessor) map[QualifiedName]string {
+		m := map[QualifiedName]string{}
+		prefixes := map[QualifiedName]int{}
+		for k, v := range accessor.GetObjectMeta().GetAnnotations() {
+			ll := log.WithValues("key", k, "value", v)
+			n, i, ok := s.Parse(k)
+			if !ok {
+				ll.Info("skip irrelevant annotation")
+				continue
+			}
+			if j, found := prefixes[n]; found && j <= i {
+				ll.Info("skip annotation overridden by a preferred prefix")
+				continue
+			}
+			if n.Qualifier == "" {
 				ll.Info("dynamic argumentation")
-				m[QualifiedName{Name: n}] = v
 			} else {
 				ll.Info("designated argumentation")
-				m[QualifiedName{
-					Qualifier: n[i+1:],
-					Name:      n[:i],
-				}] = v
 			}
-		} else {
-			ll.Info("skip irrelevant annotation")
+			m[n] = v
+			prefixes[n] = i
 		}
+		return m
+	}
+}
+
+// ValidatePrefix checks that a prefix is a DNS subdomain followed by a slash, as annotation key prefixes are
+func ValidatePrefix(prefix string) error {
+	if !strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("annotation prefix %q must end with /", prefix)
+	}
+	if errs := validation.IsDNS1123Subdomain(strings.TrimSuffix(prefix, "/")); len(errs) > 0 {
+		return fmt.Errorf("invalid annotation prefix %q: %s", prefix, strings.Join(errs, ", "))
 	}
-	return m
+	return nil
 }
 
 type PodQualifier func(int, string) bool
@@ -86,27 +192,102 @@ var CommonPodQualifier PodQualifier = func(ordinal int, q string) bool {
 		ll.Info("pod is always included for dynamic argumentation")
 		return true
 	}
-	bounds := strings.Split(q, "-")
-	if b, err := regexp.MatchString("^\\d+-\\d+$", q); err == nil && b {
-		min, _ := strconv.Atoi(bounds[0])
-		max, _ := strconv.Atoi(bounds[1])
-		ll.Info("check ordinal against range", "min", min, "max", max)
-		return ordinal >= min && ordinal <= max
+	r, err := ParseQualifier(q)
+	if err != nil {
+		ll.Info("unexpected qualifier", "error", err)
+		return false
 	}
-	if b, err := regexp.MatchString("^\\d+$", q); err == nil && b {
-		i, _ := strconv.Atoi(bounds[0])
-		ll.Info("check ordinal against single exact number", "number", i)
-		return ordinal == i
+	ll.Info("check ordinal against range", "min", r.Min, "max", r.Max)
+	return r.Contains(ordinal)
+}
+
+// OrdinalRange is the range of ordinals a qualifier applies to, Max is negative when there is no upper bound
+type OrdinalRange struct {
+	Min int
+	Max int
+}
+
+func (r OrdinalRange) Contains(ordinal int) bool {
+	return ordinal >= r.Min && (r.Max < 0 || ordinal <= r.Max)
+}
+
+func (r OrdinalRange) Overlaps(o OrdinalRange) bool {
+	return (r.Max < 0 || o.Min <= r.Max) && (o.Max < 0 || r.Min <= o.Max)
+}
+
+// MoreSpecific tells whether the range has fewer ordinals than the other one, bounded ranges by their width and
+// unbounded ones by their lower bound, ranges as specific as each other being ordered by lower bound
+func (r OrdinalRange) MoreSpecific(o OrdinalRange) bool {
+	switch {
+	case r.Max >= 0 && o.Max < 0:
+		return true
+	case r.Max < 0 && o.Max >= 0:
+		return false
+	case r.Max < 0:
+		return r.Min > o.Min
+	case r.Max-r.Min != o.Max-o.Min:
+		return r.Max-r.Min < o.Max-o.Min
+	default:
+		return r.Min < o.Min
 	}
-	if b, err := regexp.MatchString("^\\d+-$", q); err == nil && b {
-		min, _ := strconv.Atoi(bounds[0])
-		ll.Info("check ordinal against lower bound", "min", min)
-		return ordinal >= min
+}
+
+// LessQualifier orders qualifiers from the most specific to the least specific, so that the handlers applying a
+// single annotation take the first qualifying one, and the handlers applying all of them apply the most specific
+// last. Invalid qualifiers come last
+func LessQualifier(a, b string) bool {
+	ra, errA := ParseQualifier(a)
+	rb, errB := ParseQualifier(b)
+	switch {
+	case errA != nil || errB != nil:
+		if (errA == nil) != (errB == nil) {
+			return errA == nil
+		}
+	case ra.MoreSpecific(rb):
+		return true
+	case rb.MoreSpecific(ra):
+		return false
 	}
-	if b, err := regexp.MatchString("^-\\d+$", q); err == nil && b {
-		max, _ := strconv.Atoi(bounds[1])
-		ll.Info("check ordinal against upper bound", "max", max)
-		return ordinal <= max
+	return a < b
+}
+
+// Merging is optionally implemented by a Handler applying every annotation qualifying a pod rather than a single one,
+// in which case the qualifiers of its annotations can overlap
+type Merging interface {
+	// MergesAnnotations returns true
+	MergesAnnotations() bool
+}
+
+var (
+	rangeQualifier      = regexp.MustCompile(`^(\d+)-(\d+)$`)
+	singleQualifier     = regexp.MustCompile(`^(\d+)$`)
+	lowerBoundQualifier = regexp.MustCompile(`^(\d+)-$`)
+	upperBoundQualifier = regexp.MustCompile(`^-(\d+)$`)
+)
+
+// ParseQualifier parses the qualifier grammar: empty for all pods, N, N-M, N- or -M
+func ParseQualifier(q string) (OrdinalRange, error) {
+	switch {
+	case q == "":
+		return OrdinalRange{Min: 0, Max: -1}, nil
+	case rangeQualifier.MatchString(q):
+		m := rangeQualifier.FindStringSubmatch(q)
+		min, _ := strconv.Atoi(m[1])
+		max, _ := strconv.Atoi(m[2])
+		if max < min {
+			return OrdinalRange{}, fmt.Errorf("qualifier %q has upper bound lower than lower bound", q)
+		}
+		return OrdinalRange{Min: min, Max: max}, nil
+	case singleQualifier.MatchString(q):
+		i, _ := strconv.Atoi(q)
+		return OrdinalRange{Min: i, Max: i}, nil
+	case lowerBoundQualifier.MatchString(q):
+		min, _ := strconv.Atoi(lowerBoundQualifier.FindStringSubmatch(q)[1])
+		return OrdinalRange{Min: min, Max: -1}, nil
+	case upperBoundQualifier.MatchString(q):
+		max, _ := strconv.Atoi(upperBoundQualifier.FindStringSubmatch(q)[1])
+		return OrdinalRange{Min: 0, Max: max}, nil
+	default:
+		return OrdinalRange{}, fmt.Errorf("invalid qualifier %q, expect N, N-M, N- or -M", q)
 	}
-	return false
 }
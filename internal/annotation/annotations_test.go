@@ -2,6 +2,7 @@ package annotation
 
 import (
 	"reflect"
+	"sort"
 	"testing"
 
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -128,3 +129,177 @@ func TestPodQualifier(t *testing.T) {
 		})
 	}
 }
+
+func TestParseQualifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       string
+		want    OrdinalRange
+		wantErr bool
+	}{
+		{name: "dynamic", q: "", want: OrdinalRange{Min: 0, Max: -1}},
+		{name: "single", q: "3", want: OrdinalRange{Min: 3, Max: 3}},
+		{name: "range", q: "2-5", want: OrdinalRange{Min: 2, Max: 5}},
+		{name: "lower bound", q: "5-", want: OrdinalRange{Min: 5, Max: -1}},
+		{name: "upper bound", q: "-5", want: OrdinalRange{Min: 0, Max: 5}},
+		{name: "reversed range", q: "5-2", wantErr: true},
+		{name: "garbage", q: "1-2-3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQualifier(tt.q)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseQualifier() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseQualifier() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrdinalRange_Overlaps(t *testing.T) {
+	tests := []struct {
+		name string
+		r    OrdinalRange
+		o    OrdinalRange
+		want bool
+	}{
+		{name: "disjoint", r: OrdinalRange{Min: 0, Max: 0}, o: OrdinalRange{Min: 1, Max: -1}, want: false},
+		{name: "intersect", r: OrdinalRange{Min: 0, Max: 3}, o: OrdinalRange{Min: 3, Max: 5}, want: true},
+		{name: "unbounded", r: OrdinalRange{Min: 2, Max: -1}, o: OrdinalRange{Min: 5, Max: -1}, want: true},
+		{name: "below unbounded", r: OrdinalRange{Min: 0, Max: 1}, o: OrdinalRange{Min: 2, Max: -1}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.Overlaps(tt.o); got != tt.want {
+				t.Errorf("Overlaps() = %v, want %v", got, tt.want)
+			}
+			if got := tt.o.Overlaps(tt.r); got != tt.want {
+				t.Errorf("Overlaps() reversed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLessQualifier(t *testing.T) {
+	qualifiers := []string{"", "x", "2-", "0-9", "10", "5-", "-3", "0-", "1-2", "3"}
+	sort.Slice(qualifiers, func(i, j int) bool {
+		return LessQualifier(qualifiers[i], qualifiers[j])
+	})
+	want := []string{"3", "10", "1-2", "-3", "0-9", "5-", "2-", "", "0-", "x"}
+	if !reflect.DeepEqual(qualifiers, want) {
+		t.Errorf("LessQualifier() order = %v, want %v", qualifiers, want)
+	}
+}
+
+func TestNewCollector(t *testing.T) {
+	tests := []struct {
+		name        string
+		syntax      Syntax
+		annotations map[string]string
+		want        map[QualifiedName]string
+	}{
+		{
+			name:   "alternative syntax",
+			syntax: DefaultSyntax,
+			annotations: map[string]string{
+				"spoditor.io/mount-volume.ordinals-2-5":    "a",
+				"spoditor.io/mount-volume.ordinals-from-6": "b",
+				"spoditor.io/mount-volume.ordinals-to-1":   "c",
+				"spoditor.io/mount-volume_0":               "d",
+				"spoditor.io/on-error":                     "deny",
+				"other.io/mount-volume.ordinals-0":         "e",
+			},
+			want: map[QualifiedName]string{
+				{Name: "mount-volume", Qualifier: "2-5"}: "a",
+				{Name: "mount-volume", Qualifier: "6-"}:  "b",
+				{Name: "mount-volume", Qualifier: "-1"}:  "c",
+				{Name: "mount-volume", Qualifier: "0"}:   "d",
+				{Name: "on-error"}:                       "deny",
+			},
+		},
+		{
+			name:   "no separator",
+			syntax: Syntax{Prefixes: []string{Prefix}},
+			annotations: map[string]string{
+				"spoditor.io/my_volume.ordinals-1": "a",
+				"spoditor.io/my_volume":            "b",
+			},
+			want: map[QualifiedName]string{
+				{Name: "my_volume", Qualifier: "1"}: "a",
+				{Name: "my_volume"}:                 "b",
+			},
+		},
+		{
+			name:   "first prefix takes precedence",
+			syntax: Syntax{Prefixes: []string{"spoditor.example.com/", Prefix}, Separator: Separator},
+			annotations: map[string]string{
+				"spoditor.example.com/mount-volume_0": "new",
+				"spoditor.io/mount-volume_0":          "old",
+				"spoditor.io/mount-volume_1":          "old",
+			},
+			want: map[QualifiedName]string{
+				{Name: "mount-volume", Qualifier: "0"}: "new",
+				{Name: "mount-volume", Qualifier: "1"}: "old",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewCollector(tt.syntax).Collect(&v1.ObjectMeta{Annotations: tt.annotations})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Collect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyntax_Key(t *testing.T) {
+	alternative := Syntax{Prefixes: []string{Prefix}}
+	tests := []struct {
+		syntax Syntax
+		name   QualifiedName
+		want   string
+	}{
+		{DefaultSyntax, QualifiedName{Name: "mount-volume"}, "spoditor.io/mount-volume"},
+		{DefaultSyntax, QualifiedName{Name: "mount-volume", Qualifier: "5-"}, "spoditor.io/mount-volume_5-"},
+		{alternative, QualifiedName{Name: "mount-volume", Qualifier: "2-5"}, "spoditor.io/mount-volume.ordinals-2-5"},
+		{alternative, QualifiedName{Name: "mount-volume", Qualifier: "5-"}, "spoditor.io/mount-volume.ordinals-from-5"},
+		{alternative, QualifiedName{Name: "mount-volume", Qualifier: "-5"}, "spoditor.io/mount-volume.ordinals-to-5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.syntax.Key(tt.name); got != tt.want {
+				t.Errorf("Key() = %v, want %v", got, tt.want)
+			}
+			if got, _, _ := tt.syntax.Parse(tt.want); got != tt.name {
+				t.Errorf("Parse() = %v, want %v", got, tt.name)
+			}
+		})
+	}
+}
+
+func TestSyntax_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		syntax  Syntax
+		wantErr bool
+	}{
+		{name: "default", syntax: DefaultSyntax},
+		{name: "several prefixes", syntax: Syntax{Prefixes: []string{"spoditor.example.com/", Prefix}, Separator: "__"}},
+		{name: "no separator", syntax: Syntax{Prefixes: []string{Prefix}}},
+		{name: "no prefix", syntax: Syntax{Separator: Separator}, wantErr: true},
+		{name: "prefix without slash", syntax: Syntax{Prefixes: []string{"spoditor.io"}}, wantErr: true},
+		{name: "invalid prefix", syntax: Syntax{Prefixes: []string{"Spoditor.IO/"}}, wantErr: true},
+		{name: "dash separator", syntax: Syntax{Prefixes: []string{Prefix}, Separator: "-"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.syntax.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/json"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var log = logf.Log.WithName("external_handler")
+
+// DefaultTimeout of the calls to an external handler, short enough to answer the admission request in time
+const DefaultTimeout = 3 * time.Second
+
+// Request is POSTed as JSON to an external handler for a pod qualified by at least one of its annotations
+type Request struct {
+	// Annotation is the name of the annotation the handler claims
+	Annotation string `json:"annotation"`
+	// Pod describes the pod being mutated, its ordinal in particular
+	Pod *annotation.PodInfo `json:"pod"`
+	// Spec of the pod, mutated by the handlers registered before
+	Spec *corev1.PodSpec `json:"spec"`
+	// Configs are the JSON values of the annotations qualifying the pod, ordered from the most specific qualifier
+	Configs []Config `json:"configs"`
+}
+
+// Config is the value of an annotation, parsed as JSON
+type Config struct {
+	Qualifier string               `json:"qualifier"`
+	Value     runtime.RawExtension `json:"value"`
+}
+
+// Response of an external handler
+type Response struct {
+	// Patch is a JSON patch applied to the pod spec, paths being relative to the spec, e.g. /containers/0/env
+	Patch runtime.RawExtension `json:"patch,omitempty"`
+	// Error fails the mutation
+	Error string `json:"error,omitempty"`
+}
+
+// Handler delegates the mutation of the pods qualified by its annotation to an HTTP service
+type Handler struct {
+	// AnnotationName claimed by the handler, e.g. sidecar for spoditor.io/sidecar annotations
+	AnnotationName string
+	URL            string
+	Timeout        time.Duration
+	// Order is the priority of the handler, annotation.DefaultPriority when nil
+	Order *int
+	// Dependencies are the names of the handlers it is applied after
+	Dependencies []string
+	// Client is optional, http.DefaultClient is used when nil
+	Client *http.Client
+}
+
+var _ annotation.Handler = &Handler{}
+var _ annotation.Named = &Handler{}
+var _ annotation.PodHandler = &Handler{}
+var _ annotation.Merging = &Handler{}
+var _ annotation.ContextPodHandler = &Handler{}
+var _ annotation.Prioritized = &Handler{}
+var _ annotation.Dependent = &Handler{}
+
+// externalConfig holds the parsed values of the annotations of the handler, by qualifier
+type externalConfig map[string]runtime.RawExtension
+
+func (h *Handler) Name() string {
+	return h.AnnotationName
+}
+
+func (h *Handler) Priority() int {
+	if h.Order == nil {
+		return annotation.DefaultPriority
+	}
+	return *h.Order
+}
+
+func (h *Handler) DependsOn() []string {
+	return h.Dependencies
+}
+
+// MergesAnnotations returns true, the service getting every annotation qualifying the pod
+func (h *Handler) MergesAnnotations() bool {
+	return true
+}
+
+func (h *Handler) Mutate(spec *corev1.PodSpec, ordinal int, cfg interface{}) error {
+	return h.MutatePod(spec, annotation.NewPodInfo(ordinal), cfg)
+}
+
+func (h *Handler) MutatePod(spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	return h.MutatePodContext(context.Background(), spec, pod, cfg)
+}
+
+// MutatePodContext calls the external handler, the call being canceled with the admission request
+func (h *Handler) MutatePodContext(ctx context.Context, spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	ll := log.WithValues("handler", h.AnnotationName, "ordinal", pod.Ordinal)
+	c, ok := cfg.(externalConfig)
+	if !ok {
+		return fmt.Errorf("unexpected config type %T", cfg)
+	}
+	req := &Request{Annotation: h.AnnotationName, Pod: pod, Spec: spec}
+	for q, v := range c {
+		if annotation.CommonPodQualifier(pod.Ordinal, q) {
+			req.Configs = append(req.Configs, Config{Qualifier: q, Value: v})
+		}
+	}
+	if len(req.Configs) == 0 {
+		ll.Info("no annotation qualifies this pod")
+		return nil
+	}
+	sort.Slice(req.Configs, func(i, j int) bool {
+		return annotation.LessQualifier(req.Configs[i].Qualifier, req.Configs[j].Qualifier)
+	})
+	resp, err := h.call(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("external handler %s failed: %s", h.AnnotationName, resp.Error)
+	}
+	if len(resp.Patch.Raw) == 0 {
+		ll.Info("external handler returned no patch")
+		return nil
+	}
+	patch, err := jsonpatch.DecodePatch(resp.Patch.Raw)
+	if err != nil {
+		return fmt.Errorf("invalid patch from external handler %s: %v", h.AnnotationName, err)
+	}
+	original, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	patched, err := patch.Apply(original)
+	if err != nil {
+		return fmt.Errorf("failed to apply patch of external handler %s: %v", h.AnnotationName, err)
+	}
+	mutated := &corev1.PodSpec{}
+	if err := json.Unmarshal(patched, mutated); err != nil {
+		return fmt.Errorf("invalid pod spec patched by external handler %s: %v", h.AnnotationName, err)
+	}
+	if err := pod.CheckNames(spec, mutated); err != nil {
+		return fmt.Errorf("patch of external handler %s: %v", h.AnnotationName, err)
+	}
+	ll.Info("applied patch of external handler", "patch", string(resp.Patch.Raw))
+	*spec = *mutated
+	return nil
+}
+
+func (h *Handler) call(ctx context.Context, req *Request) (*Response, error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hr, err := client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call external handler %s: %v", h.AnnotationName, err)
+	}
+	defer hr.Body.Close()
+	body, err := ioutil.ReadAll(hr.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response of external handler %s: %v", h.AnnotationName, err)
+	}
+	if hr.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("external handler %s answered %s: %s", h.AnnotationName, hr.Status, strings.TrimSpace(string(body)))
+	}
+	resp := &Response{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("invalid response of external handler %s: %v", h.AnnotationName, err)
+	}
+	return resp, nil
+}
+
+func (h *Handler) GetParser() annotation.Parser {
+	return annotation.ParserFunc(func(annotations map[annotation.QualifiedName]string) (interface{}, error) {
+		c := externalConfig{}
+		for k, v := range annotations {
+			if k.Name != h.AnnotationName {
+				continue
+			}
+			var value runtime.RawExtension
+			if err := json.Unmarshal([]byte(v), &value); err != nil {
+				return nil, fmt.Errorf("%s annotation is not JSON: %v", h.AnnotationName, err)
+			}
+			c[k.Qualifier] = value
+		}
+		if len(c) == 0 {
+			return nil, nil
+		}
+		return c, nil
+	})
+}
+
+// Parse parses an external handler flag,
+// <annotation name>=<url>[,timeout=<duration>][,priority=<priority>][,dependsOn=<annotation name>]...
+func Parse(s string) (*Handler, error) {
+	h := &Handler{}
+	nameURL := s
+	var opts string
+	if i := strings.Index(s, ","); i != -1 {
+		nameURL, opts = s[:i], s[i+1:]
+	}
+	kv := strings.SplitN(nameURL, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return nil, fmt.Errorf("invalid external handler %q, expect <annotation name>=<url>", s)
+	}
+	h.AnnotationName = kv[0]
+	u, err := url.Parse(kv[1])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid external handler url %q, expect http(s)://<host>[/<path>]", kv[1])
+	}
+	h.URL = kv[1]
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == "" {
+			continue
+		}
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid external handler option %q", opt)
+		}
+		switch kv[0] {
+		case "timeout":
+			d, err := time.ParseDuration(kv[1])
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid external handler timeout %q", kv[1])
+			}
+			h.Timeout = d
+		case "priority":
+			p, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid external handler priority %q", kv[1])
+			}
+			h.Order = &p
+		case "dependsOn":
+			h.Dependencies = append(h.Dependencies, kv[1])
+		default:
+			return nil, fmt.Errorf("unknown external handler option %q", kv[0])
+		}
+	}
+	return h, nil
+}
+
+func (h *Handler) String() string {
+	s := h.AnnotationName + "=" + h.URL
+	if h.Timeout > 0 {
+		s += ",timeout=" + h.Timeout.String()
+	}
+	if h.Order != nil {
+		s += ",priority=" + strconv.Itoa(*h.Order)
+	}
+	for _, d := range h.Dependencies {
+		s += ",dependsOn=" + d
+	}
+	return s
+}
+
+// Handlers is a repeatable flag of external handlers
+type Handlers []*Handler
+
+func (hs *Handlers) String() string {
+	var s []string
+	for _, h := range *hs {
+		s = append(s, h.String())
+	}
+	return strings.Join(s, " ")
+}
+
+func (hs *Handlers) Set(s string) error {
+	h, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*hs = append(*hs, h)
+	return nil
+}
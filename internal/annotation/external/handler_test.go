@@ -0,0 +1,229 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// stub answers with the env variable of the first config value, the patch or the error of the config
+func stub(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &Request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		var cfg struct {
+			Value string          `json:"value"`
+			Error string          `json:"error"`
+			Sleep string          `json:"sleep"`
+			Patch json.RawMessage `json:"patch"`
+		}
+		if err := json.Unmarshal(req.Configs[0].Value.Raw, &cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Sleep != "" {
+			d, _ := time.ParseDuration(cfg.Sleep)
+			time.Sleep(d)
+		}
+		if cfg.Error == "status" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		if cfg.Patch != nil {
+			_ = json.NewEncoder(w).Encode(&Response{Patch: runtime.RawExtension{Raw: cfg.Patch}})
+			return
+		}
+		patch, _ := json.Marshal([]map[string]interface{}{{
+			"op":    "add",
+			"path":  "/containers/0/env",
+			"value": []corev1.EnvVar{{Name: "VALUE", Value: cfg.Value}, {Name: "STS", Value: req.Pod.StatefulSet}},
+		}})
+		_ = json.NewEncoder(w).Encode(&Response{Patch: runtime.RawExtension{Raw: patch}, Error: cfg.Error})
+	}))
+}
+
+func TestHandler_MutatePod(t *testing.T) {
+	s := stub(t)
+	defer s.Close()
+	h := &Handler{AnnotationName: "env", URL: s.URL, Timeout: 200 * time.Millisecond}
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		ordinal     int
+		want        []corev1.EnvVar
+		wantErr     bool
+	}{
+		{
+			name:        "qualified annotation",
+			annotations: map[annotation.QualifiedName]string{{Name: "env", Qualifier: "1-"}: `{"value":"replica"}`},
+			ordinal:     1,
+			want:        []corev1.EnvVar{{Name: "VALUE", Value: "replica"}, {Name: "STS", Value: "web"}},
+		},
+		{
+			name:        "no qualified annotation",
+			annotations: map[annotation.QualifiedName]string{{Name: "env", Qualifier: "1-"}: `{"value":"replica"}`},
+			ordinal:     0,
+		},
+		{
+			name:        "handler error",
+			annotations: map[annotation.QualifiedName]string{{Name: "env"}: `{"error":"no way"}`},
+			wantErr:     true,
+		},
+		{
+			name:        "handler status",
+			annotations: map[annotation.QualifiedName]string{{Name: "env"}: `{"error":"status"}`},
+			wantErr:     true,
+		},
+		{
+			name: "secret named after the statefulset",
+			annotations: map[annotation.QualifiedName]string{{Name: "env"}: `{"patch":[
+				{"op":"add","path":"/volumes","value":[{"name":"tls","secret":{"secretName":"web-tls-1"}}]}]}`},
+		},
+		{
+			name: "secret of another workload",
+			annotations: map[annotation.QualifiedName]string{{Name: "env"}: `{"patch":[
+				{"op":"add","path":"/volumes","value":[{"name":"tls","secret":{"secretName":"webx-tls"}}]}]}`},
+			wantErr: true,
+		},
+		{
+			name: "env from a configmap of another workload",
+			annotations: map[annotation.QualifiedName]string{{Name: "env"}: `{"patch":[
+				{"op":"add","path":"/containers/0/envFrom","value":[{"configMapRef":{"name":"other-team"}}]}]}`},
+			wantErr: true,
+		},
+		{
+			name:        "handler timeout",
+			annotations: map[annotation.QualifiedName]string{{Name: "env"}: `{"sleep":"1s"}`},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := h.GetParser().Parse(tt.annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := annotation.NewPodInfo(tt.ordinal)
+			pod.StatefulSet = "web"
+			spec := &corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx"}}}
+			err = h.MutatePod(spec, pod, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MutatePod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(spec.Containers[0].Env, tt.want) {
+				t.Errorf("MutatePod() env = %v, want %v", spec.Containers[0].Env, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_MutatePodContext(t *testing.T) {
+	s := stub(t)
+	defer s.Close()
+	h := &Handler{AnnotationName: "env", URL: s.URL}
+	cfg, err := h.GetParser().Parse(map[annotation.QualifiedName]string{{Name: "env"}: `{"sleep":"1s"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	spec := &corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx"}}}
+	if err := h.MutatePodContext(ctx, spec, annotation.NewPodInfo(0), cfg); err == nil {
+		t.Errorf("MutatePodContext() expected error when the admission request is canceled")
+	}
+}
+
+func TestRequest_JSON(t *testing.T) {
+	pod := annotation.NewPodInfo(1)
+	pod.Name, pod.Namespace, pod.StatefulSet = "web-1", "default", "web"
+	got, err := json.Marshal(&Request{Annotation: "env", Pod: pod})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"annotation":"env","pod":{"name":"web-1","namespace":"default","statefulSet":"web","ordinal":1,` +
+		`"absoluteOrdinal":1,"logicalOrdinal":1},"spec":null,"configs":null}`
+	if string(got) != want {
+		t.Errorf("json.Marshal() = %s, want %s", got, want)
+	}
+}
+
+func TestHandler_GetParser(t *testing.T) {
+	h := &Handler{AnnotationName: "env"}
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		want        interface{}
+		wantErr     bool
+	}{
+		{
+			name:        "other annotations",
+			annotations: map[annotation.QualifiedName]string{{Name: "mount-volume"}: "{}"},
+			want:        nil,
+		},
+		{
+			name:        "json annotations",
+			annotations: map[annotation.QualifiedName]string{{Name: "env"}: `"a"`, {Name: "env", Qualifier: "0"}: `{}`},
+			want:        externalConfig{"": {Raw: []byte(`"a"`)}, "0": {Raw: []byte(`{}`)}},
+		},
+		{
+			name:        "invalid json",
+			annotations: map[annotation.QualifiedName]string{{Name: "env"}: `not json`},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.GetParser().Parse(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	ten := 10
+	tests := []struct {
+		s       string
+		want    *Handler
+		wantErr bool
+	}{
+		{s: "env=http://env.default.svc/mutate", want: &Handler{AnnotationName: "env", URL: "http://env.default.svc/mutate"}},
+		{s: "env=https://env:8443,timeout=1s", want: &Handler{AnnotationName: "env", URL: "https://env:8443", Timeout: time.Second}},
+		{
+			s:    "env=http://env,priority=10,dependsOn=mount-volume,dependsOn=claim-volume",
+			want: &Handler{AnnotationName: "env", URL: "http://env", Order: &ten, Dependencies: []string{"mount-volume", "claim-volume"}},
+		},
+		{s: "env=http://env,priority=first", wantErr: true},
+		{s: "env", wantErr: true},
+		{s: "env=env.default.svc", wantErr: true},
+		{s: "env=http://env,timeout=soon", wantErr: true},
+		{s: "env=http://env,retries=3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := Parse(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() got = %v, want %v", got, tt.want)
+			}
+			if got != nil && got.String() != tt.s {
+				t.Errorf("String() got = %v, want %v", got.String(), tt.s)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package identity
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	ServiceAccount = "service-account"
+)
+
+// TokenPath is where the ServiceAccount admission plugin mounts the token of the pod service account
+const TokenPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// lookupTimeout bounds the lookup of a service account, short enough to answer the admission request in time
+const lookupTimeout = 2 * time.Second
+
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get
+
+var log = logf.Log.WithName("service_account")
+
+type accountConfig []accountConfigEntry
+
+type accountConfigEntry struct {
+	qualifier string
+	cfg       *accountConfigValue
+}
+
+type accountConfigValue struct {
+	// Name of the service account, suffixed with -<ordinal> unless it is a name template
+	Name string `json:"name"`
+	// AutomountServiceAccountToken is optional, when false the token mounted by the ServiceAccount admission plugin
+	// is removed. It can't be true, the webhook being called after the plugin would have mounted the token
+	AutomountServiceAccountToken *bool `json:"automountServiceAccountToken,omitempty"`
+}
+
+// ServiceAccountHandler sets a per-ordinal service account, e.g. for each member of a StatefulSet to have its own
+// cloud identity
+type ServiceAccountHandler struct {
+	// ServiceAccounts is optional, when set the service account must exist in the pod namespace
+	ServiceAccounts client.Reader
+}
+
+func (h *ServiceAccountHandler) Mutate(spec *corev1.PodSpec, ordinal int, cfg interface{}) error {
+	return h.MutatePod(spec, annotation.NewPodInfo(ordinal), cfg)
+}
+
+func (h *ServiceAccountHandler) MutatePod(spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	return h.MutatePodContext(context.Background(), spec, pod, cfg)
+}
+
+// MutatePodContext sets the service account of the most specific qualifying annotation, looked up with the
+// admission request
+func (h *ServiceAccountHandler) MutatePodContext(ctx context.Context, spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	ll := log.WithValues("ordinal", pod.Ordinal)
+	c, ok := cfg.(accountConfig)
+	if !ok {
+		return fmt.Errorf("unexpected config type %T", cfg)
+	}
+	// the most specific qualifying annotation wins, as a pod has a single service account
+	for _, e := range c {
+		if !annotation.CommonPodQualifier(pod.Ordinal, e.qualifier) {
+			ll.Info("qualifier excludes this pod", "qualifier", e.qualifier)
+			continue
+		}
+		name, err := pod.ExpandName(e.cfg.Name)
+		if err != nil {
+			return err
+		}
+		sa, err := h.lookup(ctx, pod.Namespace, name)
+		if err != nil {
+			return err
+		}
+		ll.Info("set service account", "origin", spec.ServiceAccountName, "new", name)
+		spec.ServiceAccountName = name
+		spec.DeprecatedServiceAccount = name
+		if a := e.cfg.AutomountServiceAccountToken; a != nil {
+			spec.AutomountServiceAccountToken = a
+			if !*a {
+				removeToken(spec)
+			}
+		}
+		if sa != nil {
+			addImagePullSecrets(spec, sa.ImagePullSecrets)
+		}
+		return nil
+	}
+	return nil
+}
+
+// lookup gets the service account, nil without ServiceAccounts
+func (h *ServiceAccountHandler) lookup(ctx context.Context, namespace, name string) (*corev1.ServiceAccount, error) {
+	if h.ServiceAccounts == nil {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	sa := &corev1.ServiceAccount{}
+	if err := h.ServiceAccounts.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, sa); err != nil {
+		return nil, fmt.Errorf("failed to get service account %s: %v", name, err)
+	}
+	return sa, nil
+}
+
+// addImagePullSecrets adds the image pull secrets of the service account missing from the pod, as the
+// ServiceAccount admission plugin only added those of the service account of the pod template
+func addImagePullSecrets(spec *corev1.PodSpec, secrets []corev1.LocalObjectReference) {
+	existing := map[string]bool{}
+	for _, s := range spec.ImagePullSecrets {
+		existing[s.Name] = true
+	}
+	for _, s := range secrets {
+		if !existing[s.Name] {
+			spec.ImagePullSecrets = append(spec.ImagePullSecrets, s)
+			existing[s.Name] = true
+		}
+	}
+}
+
+// removeToken removes the volumes mounted at the token path, and their mounts, the ServiceAccount admission plugin
+// injecting the token before the webhook is called
+func removeToken(spec *corev1.PodSpec) {
+	tokens := map[string]bool{}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			var mounts []corev1.VolumeMount
+			for _, m := range containers[i].VolumeMounts {
+				if m.MountPath == TokenPath {
+					tokens[m.Name] = true
+					continue
+				}
+				mounts = append(mounts, m)
+			}
+			containers[i].VolumeMounts = mounts
+		}
+	}
+	var volumes []corev1.Volume
+	for _, v := range spec.Volumes {
+		if !tokens[v.Name] {
+			volumes = append(volumes, v)
+		}
+	}
+	spec.Volumes = volumes
+}
+
+func (h *ServiceAccountHandler) Name() string {
+	return ServiceAccount
+}
+
+func (h *ServiceAccountHandler) GetParser() annotation.Parser {
+	return accountParser
+}
+
+var _ annotation.Handler = &ServiceAccountHandler{}
+var _ annotation.Named = &ServiceAccountHandler{}
+var _ annotation.PodHandler = &ServiceAccountHandler{}
+var _ annotation.ContextPodHandler = &ServiceAccountHandler{}
+var _ annotation.Prioritized = &ServiceAccountHandler{}
+
+// Priority of the handler, applied before the handlers of the default priority so that it only removes the token
+// the ServiceAccount admission plugin mounted, not a token mount-volume mounts at the same path
+func (h *ServiceAccountHandler) Priority() int {
+	return annotation.DefaultPriority / 2
+}
+
+// accountParser parses all the service-account annotations, ordered from the most specific qualifier
+var accountParser annotation.ParserFunc = func(annotations map[annotation.QualifiedName]string) (interface{}, error) {
+	var c accountConfig
+	for k, v := range annotations {
+		if k.Name != ServiceAccount {
+			continue
+		}
+		log.Info("parse config for service account", "qualifiedName", k, "value", v)
+		value := &accountConfigValue{}
+		if err := json.Unmarshal([]byte(v), value); err != nil {
+			return nil, err
+		}
+		if value.Name == "" {
+			return nil, fmt.Errorf("%s annotation has no name", ServiceAccount)
+		}
+		if a := value.AutomountServiceAccountToken; a != nil && *a {
+			return nil, fmt.Errorf("%s annotation can't mount the token with automountServiceAccountToken true, "+
+				"the ServiceAccount admission plugin mounts it before the webhook is called: "+
+				"omit the field to keep the token of the pod template, or set it to false to remove it", ServiceAccount)
+		}
+		c = append(c, accountConfigEntry{qualifier: k.Qualifier, cfg: value})
+	}
+	if c == nil {
+		return nil, nil
+	}
+	sort.Slice(c, func(i, j int) bool {
+		return annotation.LessQualifier(c[i].qualifier, c[j].qualifier)
+	})
+	return c, nil
+}
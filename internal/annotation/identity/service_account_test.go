@@ -0,0 +1,142 @@
+package identity
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestServiceAccountHandler_MutatePodContext(t *testing.T) {
+	accounts := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-sa-1"}},
+		&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-1-identity"}},
+		&v1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Namespace: "default", Name: "registry-sa-1"},
+			ImagePullSecrets: []v1.LocalObjectReference{{Name: "default-registry"}, {Name: "private-registry"}},
+		},
+	).Build()
+	newSpec := func() *v1.PodSpec {
+		return &v1.PodSpec{
+			ServiceAccountName: "default",
+			ImagePullSecrets:   []v1.LocalObjectReference{{Name: "default-registry"}},
+			Volumes:            []v1.Volume{{Name: "kube-api-access-x"}, {Name: "data"}},
+			Containers: []v1.Container{{
+				Name: "db",
+				VolumeMounts: []v1.VolumeMount{
+					{Name: "data", MountPath: "/data"},
+					{Name: "kube-api-access-x", MountPath: TokenPath},
+				},
+			}},
+		}
+	}
+	automount := false
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		ordinal     int
+		want        func(*v1.PodSpec)
+		wantErr     bool
+	}{
+		{
+			name:        "name suffixed with ordinal",
+			annotations: map[annotation.QualifiedName]string{{Name: ServiceAccount}: `{"name":"db-sa"}`},
+			ordinal:     1,
+			want: func(s *v1.PodSpec) {
+				s.ServiceAccountName = "db-sa-1"
+				s.DeprecatedServiceAccount = "db-sa-1"
+			},
+		},
+		{
+			name: "name template without token",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ServiceAccount, Qualifier: "1-"}: `{"name":"{{.Name}}-identity","automountServiceAccountToken":false}`,
+			},
+			ordinal: 1,
+			want: func(s *v1.PodSpec) {
+				s.ServiceAccountName = "web-1-identity"
+				s.DeprecatedServiceAccount = "web-1-identity"
+				s.AutomountServiceAccountToken = &automount
+				s.Volumes = []v1.Volume{{Name: "data"}}
+				s.Containers[0].VolumeMounts = []v1.VolumeMount{{Name: "data", MountPath: "/data"}}
+			},
+		},
+		{
+			name:        "image pull secrets of the service account merged",
+			annotations: map[annotation.QualifiedName]string{{Name: ServiceAccount}: `{"name":"registry-sa"}`},
+			ordinal:     1,
+			want: func(s *v1.PodSpec) {
+				s.ServiceAccountName = "registry-sa-1"
+				s.DeprecatedServiceAccount = "registry-sa-1"
+				s.ImagePullSecrets = []v1.LocalObjectReference{{Name: "default-registry"}, {Name: "private-registry"}}
+			},
+		},
+		{
+			name:        "qualifier excludes ordinal",
+			annotations: map[annotation.QualifiedName]string{{Name: ServiceAccount, Qualifier: "0"}: `{"name":"db-sa"}`},
+			ordinal:     1,
+			want:        func(s *v1.PodSpec) {},
+		},
+		{
+			name:        "missing service account",
+			annotations: map[annotation.QualifiedName]string{{Name: ServiceAccount}: `{"name":"db-sa"}`},
+			ordinal:     2,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &ServiceAccountHandler{ServiceAccounts: accounts}
+			cfg, err := h.GetParser().Parse(tt.annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := annotation.NewPodInfo(tt.ordinal)
+			pod.Name = "web-1"
+			pod.Namespace = "default"
+			pod.StatefulSet = "web"
+			spec := newSpec()
+			if err := h.MutatePodContext(context.TODO(), spec, pod, cfg); (err != nil) != tt.wantErr {
+				t.Fatalf("MutatePodContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := newSpec()
+			tt.want(want)
+			if !reflect.DeepEqual(spec, want) {
+				t.Errorf("MutatePodContext() = %+v, want %+v", spec, want)
+			}
+		})
+	}
+}
+
+func Test_accountParser_Parse(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		wantNil     bool
+		wantErr     bool
+	}{
+		{name: "no annotation", annotations: map[annotation.QualifiedName]string{{Name: "mount-volume"}: "{}"}, wantNil: true},
+		{name: "not json", annotations: map[annotation.QualifiedName]string{{Name: ServiceAccount}: "db-sa"}, wantErr: true},
+		{name: "no name", annotations: map[annotation.QualifiedName]string{{Name: ServiceAccount}: `{"automountServiceAccountToken":false}`}, wantErr: true},
+		{name: "token mount", annotations: map[annotation.QualifiedName]string{{Name: ServiceAccount}: `{"name":"db-sa","automountServiceAccountToken":true}`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := accountParser.Parse(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.wantNil || tt.wantErr) {
+				t.Errorf("Parse() got = %v", got)
+			}
+		})
+	}
+}
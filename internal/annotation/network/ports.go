@@ -0,0 +1,225 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	ContainerPorts = "container-ports"
+)
+
+var log = logf.Log.WithName("container_ports")
+
+type portConfig []portConfigEntry
+
+type portConfigEntry struct {
+	qualifier string
+	cfg       *portConfigValue
+}
+
+type portConfigValue struct {
+	Ports []ordinalPort `json:"ports"`
+}
+
+// ordinalPort is a port of a container computed from a base port plus the ordinal
+type ordinalPort struct {
+	// Container to add the port to
+	Container string `json:"container"`
+	// Name of the port, optional
+	Name string `json:"name,omitempty"`
+	// BasePort plus the ordinal is the computed port
+	BasePort int32 `json:"basePort"`
+	// ContainerPort is optional, the computed port being the container port without it
+	ContainerPort int32 `json:"containerPort,omitempty"`
+	// HostPort exposes the computed port on the host
+	HostPort bool `json:"hostPort,omitempty"`
+	// Protocol is TCP by default
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+	// Env is optional, the name of the environment variable of the container the computed port is injected as
+	Env string `json:"env,omitempty"`
+}
+
+// PortHandler adds per-ordinal container ports or host ports, e.g. for brokers advertising stable external ports
+type PortHandler struct {
+}
+
+func (h *PortHandler) Mutate(spec *corev1.PodSpec, ordinal int, cfg interface{}) error {
+	return h.MutatePod(spec, annotation.NewPodInfo(ordinal), cfg)
+}
+
+func (h *PortHandler) MutatePod(spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	ll := log.WithValues("ordinal", pod.Ordinal)
+	c, ok := cfg.(portConfig)
+	if !ok {
+		return fmt.Errorf("unexpected config type %T", cfg)
+	}
+	for _, e := range c {
+		if !annotation.CommonPodQualifier(pod.Ordinal, e.qualifier) {
+			ll.Info("qualifier excludes this pod", "qualifier", e.qualifier)
+			continue
+		}
+		for _, p := range e.cfg.Ports {
+			port, err := p.toContainerPort(pod.Ordinal)
+			if err != nil {
+				return err
+			}
+			container := containerNamed(spec, p.Container)
+			if container == nil {
+				return fmt.Errorf("%s annotation container %s not found", ContainerPorts, p.Container)
+			}
+			if err := checkConflicts(spec, container, port); err != nil {
+				return err
+			}
+			ll.Info("add port", "container", p.Container, "port", port)
+			container.Ports = append(container.Ports, port)
+			if p.Env == "" {
+				continue
+			}
+			value := strconv.Itoa(int(p.BasePort) + pod.Ordinal)
+			for _, env := range container.Env {
+				if env.Name == p.Env {
+					return fmt.Errorf("%s annotation env %s conflicts with the env of container %s", ContainerPorts, p.Env, p.Container)
+				}
+			}
+			container.Env = append(container.Env, corev1.EnvVar{Name: p.Env, Value: value})
+		}
+	}
+	return nil
+}
+
+// toContainerPort computes the port of the ordinal
+func (p *ordinalPort) toContainerPort(ordinal int) (corev1.ContainerPort, error) {
+	computed := int(p.BasePort) + ordinal
+	if errs := validation.IsValidPortNum(computed); len(errs) > 0 {
+		return corev1.ContainerPort{}, fmt.Errorf("%s annotation port %d of ordinal %d is invalid: %v",
+			ContainerPorts, computed, ordinal, errs)
+	}
+	port := corev1.ContainerPort{Name: p.Name, ContainerPort: int32(computed), Protocol: p.Protocol}
+	if p.ContainerPort != 0 {
+		port.ContainerPort = p.ContainerPort
+	}
+	if p.HostPort {
+		port.HostPort = int32(computed)
+	}
+	if port.Protocol == "" {
+		port.Protocol = corev1.ProtocolTCP
+	}
+	return port, nil
+}
+
+// checkConflicts fails when the port is already used in the pod, its containers sharing the network namespace,
+// or when its name is already used in the container
+func checkConflicts(spec *corev1.PodSpec, container *corev1.Container, port corev1.ContainerPort) error {
+	for _, c := range spec.Containers {
+		for _, existing := range c.Ports {
+			if protocolOf(existing) != port.Protocol {
+				continue
+			}
+			if existing.ContainerPort == port.ContainerPort {
+				return fmt.Errorf("%s annotation port %d/%s conflicts with a port of container %s",
+					ContainerPorts, port.ContainerPort, port.Protocol, c.Name)
+			}
+			if port.HostPort != 0 && existing.HostPort == port.HostPort {
+				return fmt.Errorf("%s annotation host port %d/%s conflicts with a host port of container %s",
+					ContainerPorts, port.HostPort, port.Protocol, c.Name)
+			}
+		}
+	}
+	if port.Name == "" {
+		return nil
+	}
+	for _, existing := range container.Ports {
+		if existing.Name == port.Name {
+			return fmt.Errorf("%s annotation port name %s conflicts with a port of container %s",
+				ContainerPorts, port.Name, container.Name)
+		}
+	}
+	return nil
+}
+
+func protocolOf(p corev1.ContainerPort) corev1.Protocol {
+	if p.Protocol == "" {
+		return corev1.ProtocolTCP
+	}
+	return p.Protocol
+}
+
+func containerNamed(spec *corev1.PodSpec, name string) *corev1.Container {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			return &spec.Containers[i]
+		}
+	}
+	return nil
+}
+
+func (h *PortHandler) Name() string {
+	return ContainerPorts
+}
+
+func (h *PortHandler) GetParser() annotation.Parser {
+	return portParser
+}
+
+var _ annotation.Handler = &PortHandler{}
+var _ annotation.Named = &PortHandler{}
+var _ annotation.PodHandler = &PortHandler{}
+var _ annotation.Merging = &PortHandler{}
+
+func (h *PortHandler) MergesAnnotations() bool {
+	return true
+}
+
+// portParser parses all the container-ports annotations, ordered from the most specific qualifier
+var portParser annotation.ParserFunc = func(annotations map[annotation.QualifiedName]string) (interface{}, error) {
+	var c portConfig
+	for k, v := range annotations {
+		if k.Name != ContainerPorts {
+			continue
+		}
+		log.Info("parse config for container ports", "qualifiedName", k, "value", v)
+		// a misspelled field, e.g. containerPorts, would silently change the computed ports
+		d := json.NewDecoder(strings.NewReader(v))
+		d.DisallowUnknownFields()
+		value := &portConfigValue{}
+		if err := d.Decode(value); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", ContainerPorts, err)
+		}
+		for _, p := range value.Ports {
+			if p.Container == "" {
+				return nil, fmt.Errorf("%s annotation has a port without container", ContainerPorts)
+			}
+			if errs := validation.IsValidPortNum(int(p.BasePort)); len(errs) > 0 {
+				return nil, fmt.Errorf("%s annotation has invalid base port %d", ContainerPorts, p.BasePort)
+			}
+			if p.ContainerPort != 0 && !p.HostPort {
+				return nil, fmt.Errorf("%s annotation port %d has a fixed containerPort but no hostPort", ContainerPorts, p.BasePort)
+			}
+			if errs := validation.IsValidPortNum(int(p.ContainerPort)); p.ContainerPort != 0 && len(errs) > 0 {
+				return nil, fmt.Errorf("%s annotation has invalid container port %d", ContainerPorts, p.ContainerPort)
+			}
+			if p.Name != "" {
+				if errs := validation.IsValidPortName(p.Name); len(errs) > 0 {
+					return nil, fmt.Errorf("%s annotation has invalid port name %s", ContainerPorts, p.Name)
+				}
+			}
+		}
+		c = append(c, portConfigEntry{qualifier: k.Qualifier, cfg: value})
+	}
+	if c == nil {
+		return nil, nil
+	}
+	sort.Slice(c, func(i, j int) bool {
+		return annotation.LessQualifier(c[i].qualifier, c[j].qualifier)
+	})
+	return c, nil
+}
@@ -0,0 +1,175 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestPortHandler_MutatePod(t *testing.T) {
+	newSpec := func() *v1.PodSpec {
+		return &v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:  "kafka",
+					Ports: []v1.ContainerPort{{Name: "internal", ContainerPort: 9092}},
+					Env:   []v1.EnvVar{{Name: "BROKER", Value: "kafka"}},
+				},
+				{Name: "exporter", Ports: []v1.ContainerPort{{Name: "metrics", ContainerPort: 9308, HostPort: 9308}}},
+			},
+		}
+	}
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		ordinal     int
+		want        func(*v1.PodSpec)
+		wantErr     bool
+	}{
+		{
+			name: "container port and env",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ContainerPorts}: `{"ports":[{"container":"kafka","name":"external","basePort":9093,"env":"EXTERNAL_PORT"}]}`,
+			},
+			ordinal: 2,
+			want: func(s *v1.PodSpec) {
+				s.Containers[0].Ports = append(s.Containers[0].Ports, v1.ContainerPort{Name: "external", ContainerPort: 9095, Protocol: v1.ProtocolTCP})
+				s.Containers[0].Env = append(s.Containers[0].Env, v1.EnvVar{Name: "EXTERNAL_PORT", Value: "9095"})
+			},
+		},
+		{
+			name: "host port of a fixed container port",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ContainerPorts, Qualifier: "1-"}: `{"ports":[{"container":"kafka","basePort":31090,"containerPort":9094,"hostPort":true,"protocol":"UDP"}]}`,
+			},
+			ordinal: 1,
+			want: func(s *v1.PodSpec) {
+				s.Containers[0].Ports = append(s.Containers[0].Ports, v1.ContainerPort{ContainerPort: 9094, HostPort: 31091, Protocol: v1.ProtocolUDP})
+			},
+		},
+		{
+			name: "qualifier excludes ordinal",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ContainerPorts, Qualifier: "0"}: `{"ports":[{"container":"kafka","basePort":9093}]}`,
+			},
+			ordinal: 1,
+			want:    func(s *v1.PodSpec) {},
+		},
+		{
+			name: "conflict with the port of another container",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ContainerPorts}: `{"ports":[{"container":"kafka","basePort":9306}]}`,
+			},
+			ordinal: 2,
+			wantErr: true,
+		},
+		{
+			name: "conflict with a host port",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ContainerPorts}: `{"ports":[{"container":"kafka","basePort":9300,"containerPort":9094,"hostPort":true}]}`,
+			},
+			ordinal: 8,
+			wantErr: true,
+		},
+		{
+			name: "conflict between qualified annotations",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ContainerPorts, Qualifier: "0-"}: `{"ports":[{"container":"kafka","basePort":9100}]}`,
+				{Name: ContainerPorts, Qualifier: "1-"}: `{"ports":[{"container":"kafka","basePort":9100}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+		{
+			name: "conflict with a port name",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ContainerPorts}: `{"ports":[{"container":"kafka","name":"internal","basePort":9100}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+		{
+			name: "conflict with an env",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ContainerPorts}: `{"ports":[{"container":"kafka","basePort":9100,"env":"BROKER"}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+		{
+			name: "port out of range",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ContainerPorts}: `{"ports":[{"container":"kafka","basePort":65535}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+		{
+			name: "unknown container",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ContainerPorts}: `{"ports":[{"container":"zookeeper","basePort":2181}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &PortHandler{}
+			cfg, err := h.GetParser().Parse(tt.annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec := newSpec()
+			if err := h.MutatePod(spec, annotation.NewPodInfo(tt.ordinal), cfg); (err != nil) != tt.wantErr {
+				t.Fatalf("MutatePod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := newSpec()
+			tt.want(want)
+			if !reflect.DeepEqual(spec, want) {
+				t.Errorf("MutatePod() = %+v, want %+v", spec, want)
+			}
+		})
+	}
+}
+
+func Test_portParser_Parse(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		wantNil     bool
+		wantErr     bool
+	}{
+		{name: "no annotation", annotations: map[annotation.QualifiedName]string{{Name: "mount-volume"}: "{}"}, wantNil: true},
+		{name: "not json", annotations: map[annotation.QualifiedName]string{{Name: ContainerPorts}: "9092"}, wantErr: true},
+		{name: "unknown field", annotations: map[annotation.QualifiedName]string{{Name: ContainerPorts}: `{"ports":[{"container":"kafka","basePort":9092,"containerPorts":9092}]}`}, wantErr: true},
+		{name: "no container", annotations: map[annotation.QualifiedName]string{{Name: ContainerPorts}: `{"ports":[{"basePort":9092}]}`}, wantErr: true},
+		{name: "no base port", annotations: map[annotation.QualifiedName]string{{Name: ContainerPorts}: `{"ports":[{"container":"kafka"}]}`}, wantErr: true},
+		{
+			name:        "fixed container port without host port",
+			annotations: map[annotation.QualifiedName]string{{Name: ContainerPorts}: `{"ports":[{"container":"kafka","basePort":9092,"containerPort":9092}]}`},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid name",
+			annotations: map[annotation.QualifiedName]string{{Name: ContainerPorts}: `{"ports":[{"container":"kafka","basePort":9092,"name":"external_port"}]}`},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := portParser.Parse(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.wantNil || tt.wantErr) {
+				t.Errorf("Parse() got = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package annotation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// PodInfo describes the StatefulSet pod being mutated
+type PodInfo struct {
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+	StatefulSet string `json:"statefulSet"`
+	// Ordinal is the one qualifiers are evaluated against, either AbsoluteOrdinal or LogicalOrdinal
+	Ordinal int `json:"ordinal"`
+	// AbsoluteOrdinal is the ordinal suffixing the pod name
+	AbsoluteOrdinal int `json:"absoluteOrdinal"`
+	// LogicalOrdinal is relative to the spec.ordinals.start of the StatefulSet, AbsoluteOrdinal when the start isn't
+	// known, e.g. for the pods of other StatefulSet-like controllers
+	LogicalOrdinal int `json:"logicalOrdinal"`
+}
+
+// NewPodInfo describes a pod knowing only its ordinal
+func NewPodInfo(ordinal int) *PodInfo {
+	return &PodInfo{
+		Ordinal:         ordinal,
+		AbsoluteOrdinal: ordinal,
+		LogicalOrdinal:  ordinal,
+	}
+}
+
+// PodHandler is optionally implemented by a Handler needing more than the ordinal of the pod,
+// in which case it is called instead of Mutate
+type PodHandler interface {
+	MutatePod(spec *corev1.PodSpec, pod *PodInfo, cfg interface{}) error
+}
+
+// ContextPodHandler is optionally implemented by a PodHandler calling out, e.g. to the API server or a service,
+// in which case it is called instead of MutatePod with the context of the admission request
+type ContextPodHandler interface {
+	MutatePodContext(ctx context.Context, spec *corev1.PodSpec, pod *PodInfo, cfg interface{}) error
+}
+
+// IsTemplate tells whether a name is a template to expand rather than a base name to suffix with the ordinal
+func IsTemplate(name string) bool {
+	return strings.Contains(name, "{{")
+}
+
+// Expand executes a name template such as "my-secret-{{.LogicalOrdinal}}" against the pod. So that a template
+// typo can't reference the object of another workload, the expanded name must keep the base name of the template,
+// see Base
+func (p *PodInfo) Expand(name string) (string, error) {
+	expanded, err := p.ExpandText(name)
+	if err != nil {
+		return "", err
+	}
+	if errs := validation.IsDNS1123Subdomain(expanded); len(errs) > 0 {
+		return "", fmt.Errorf("name template %q expands to invalid name %q: %s", name, expanded, strings.Join(errs, ", "))
+	}
+	if err := CheckBase(p.Base(name), expanded); err != nil {
+		return "", fmt.Errorf("name template %q: %v", name, err)
+	}
+	return expanded, nil
+}
+
+// Base is the base name of a name template, its text before the first action without a trailing -, or the
+// StatefulSet name when the template starts with an action. It is the name itself when it isn't a template
+func (p *PodInfo) Base(name string) string {
+	if !IsTemplate(name) {
+		return name
+	}
+	if base := strings.TrimSuffix(name[:strings.Index(name, "{{")], "-"); base != "" {
+		return base
+	}
+	return p.StatefulSet
+}
+
+// CheckBase checks that a name is the base name, or the base name followed by - and a suffix, so that the base
+// name "db" doesn't accept "dbx" or "db2"
+func CheckBase(base, name string) error {
+	if base == "" {
+		return fmt.Errorf("name %q has no base name to keep", name)
+	}
+	if name != base && !strings.HasPrefix(name, base+"-") {
+		return fmt.Errorf("name %q is neither %q nor starts with %q", name, base, base+"-")
+	}
+	return nil
+}
+
+// ExpandText executes a template against the pod, without the checks of Expand, for values which aren't object names
+// such as a token audience or a file path
+func (p *PodInfo) ExpandText(text string) (string, error) {
+	t, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %q: %v", text, err)
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, p); err != nil {
+		return "", fmt.Errorf("failed to expand template %q: %v", text, err)
+	}
+	return b.String(), nil
+}
+
+// ExpandName expands the name when it is a template, suffixes it with -<ordinal> otherwise
+func (p *PodInfo) ExpandName(name string) (string, error) {
+	if IsTemplate(name) {
+		return p.Expand(name)
+	}
+	return fmt.Sprintf("%s-%d", name, p.Ordinal), nil
+}
+
+// CheckNames checks the names of the ConfigMaps, Secrets and claims a patch makes the pod reference, like the
+// expanded name templates: a name the pod didn't reference keeps the base name of the one its volume referenced,
+// or the StatefulSet name
+func (p *PodInfo) CheckNames(original, mutated *corev1.PodSpec) error {
+	known := map[string]bool{}
+	referenced := map[string][]string{}
+	for _, v := range original.Volumes {
+		referenced[v.Name] = volumeReferences(v)
+		for _, n := range referenced[v.Name] {
+			known[n] = true
+		}
+	}
+	for _, n := range envReferences(original) {
+		known[n] = true
+	}
+	check := func(name string, bases ...string) error {
+		if known[name] {
+			return nil
+		}
+		var err error
+		for _, base := range append(bases, p.StatefulSet) {
+			if err = CheckBase(base, name); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+	for _, v := range mutated.Volumes {
+		for _, n := range volumeReferences(v) {
+			if err := check(n, referenced[v.Name]...); err != nil {
+				return fmt.Errorf("volume %s: %v", v.Name, err)
+			}
+		}
+	}
+	for _, n := range envReferences(mutated) {
+		if err := check(n); err != nil {
+			return fmt.Errorf("env: %v", err)
+		}
+	}
+	return nil
+}
+
+// volumeReferences are the names of the ConfigMaps, Secrets and claims of a volume
+func volumeReferences(v corev1.Volume) []string {
+	var names []string
+	switch {
+	case v.ConfigMap != nil:
+		names = append(names, v.ConfigMap.Name)
+	case v.Secret != nil:
+		names = append(names, v.Secret.SecretName)
+	case v.PersistentVolumeClaim != nil:
+		names = append(names, v.PersistentVolumeClaim.ClaimName)
+	case v.Projected != nil:
+		for _, p := range v.Projected.Sources {
+			if p.ConfigMap != nil {
+				names = append(names, p.ConfigMap.Name)
+			}
+			if p.Secret != nil {
+				names = append(names, p.Secret.Name)
+			}
+		}
+	}
+	return names
+}
+
+// envReferences are the names of the ConfigMaps and Secrets of the env of the containers and init containers
+func envReferences(spec *corev1.PodSpec) []string {
+	var names []string
+	for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+		for _, e := range c.EnvFrom {
+			if e.ConfigMapRef != nil {
+				names = append(names, e.ConfigMapRef.Name)
+			}
+			if e.SecretRef != nil {
+				names = append(names, e.SecretRef.Name)
+			}
+		}
+		for _, e := range c.Env {
+			if e.ValueFrom == nil {
+				continue
+			}
+			if e.ValueFrom.ConfigMapKeyRef != nil {
+				names = append(names, e.ValueFrom.ConfigMapKeyRef.Name)
+			}
+			if e.ValueFrom.SecretKeyRef != nil {
+				names = append(names, e.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+	}
+	return names
+}
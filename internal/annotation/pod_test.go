@@ -0,0 +1,127 @@
+package annotation
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPodInfo_ExpandName(t *testing.T) {
+	pod := &PodInfo{
+		Name:            "web-7",
+		Namespace:       "default",
+		StatefulSet:     "web",
+		Ordinal:         2,
+		AbsoluteOrdinal: 7,
+		LogicalOrdinal:  2,
+	}
+	tests := []struct {
+		name    string
+		n       string
+		want    string
+		wantErr bool
+	}{
+		{name: "suffix with ordinal", n: "my-secret", want: "my-secret-2"},
+		{name: "absolute ordinal template", n: "my-secret-{{.AbsoluteOrdinal}}", want: "my-secret-7"},
+		{name: "statefulset template", n: "{{.StatefulSet}}-config-{{.LogicalOrdinal}}", want: "web-config-2"},
+		{name: "pod name template", n: "{{.Name}}-config", want: "web-7-config"},
+		{name: "conditional keeping the base name", n: "db{{if eq .Ordinal 2}}-primary{{end}}", want: "db-primary"},
+		{name: "base name not kept", n: "{{if eq .Ordinal 2}}other-team-secret{{end}}", wantErr: true},
+		{name: "ordinal first", n: "{{.Ordinal}}-config", wantErr: true},
+		{name: "base name without separator", n: "my-secret{{.Ordinal}}", wantErr: true},
+		{name: "statefulset name without separator", n: "{{.StatefulSet}}x-{{.Ordinal}}", wantErr: true},
+		{name: "exact base name", n: "{{.StatefulSet}}{{if eq .Ordinal 0}}-primary{{end}}", want: "web"},
+		{name: "invalid name", n: "my-secret/{{.Namespace}}", wantErr: true},
+		{name: "empty name", n: "{{if eq .Ordinal 0}}a{{end}}", wantErr: true},
+		{name: "unknown field", n: "{{.Replicas}}", wantErr: true},
+		{name: "invalid template", n: "{{.Ordinal", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pod.ExpandName(tt.n)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExpandName() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ExpandName() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodInfo_Expand_NoStatefulSet(t *testing.T) {
+	pod := &PodInfo{Name: "web-7", Ordinal: 7}
+	if got, err := pod.Expand("{{.Name}}-config"); err == nil {
+		t.Errorf("Expand() = %v, expects an error without base name", got)
+	}
+}
+
+func TestCheckBase(t *testing.T) {
+	tests := []struct {
+		base    string
+		name    string
+		wantErr bool
+	}{
+		{base: "db", name: "db"},
+		{base: "db", name: "db-2"},
+		{base: "db", name: "dbx", wantErr: true},
+		{base: "db", name: "other-db", wantErr: true},
+		{base: "", name: "db", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.base+"/"+tt.name, func(t *testing.T) {
+			if err := CheckBase(tt.base, tt.name); (err != nil) != tt.wantErr {
+				t.Errorf("CheckBase() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPodInfo_CheckNames(t *testing.T) {
+	secret := func(name, secretName string) corev1.Volume {
+		return corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secretName}}}
+	}
+	envFrom := func(name string) corev1.Container {
+		return corev1.Container{Name: "nginx", EnvFrom: []corev1.EnvFromSource{{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}},
+		}}}
+	}
+	original := &corev1.PodSpec{
+		Volumes:    []corev1.Volume{secret("tls", "certs")},
+		Containers: []corev1.Container{envFrom("shared-config")},
+	}
+	tests := []struct {
+		name    string
+		mutated *corev1.PodSpec
+		wantErr bool
+	}{
+		{name: "unchanged", mutated: original},
+		{name: "name of the volume base", mutated: &corev1.PodSpec{Volumes: []corev1.Volume{secret("tls", "certs-1")}}},
+		{name: "name of the statefulset", mutated: &corev1.PodSpec{Volumes: []corev1.Volume{secret("data", "web-data-1")}}},
+		{name: "other name", mutated: &corev1.PodSpec{Volumes: []corev1.Volume{secret("tls", "db-certs")}}, wantErr: true},
+		{name: "env of another statefulset", mutated: &corev1.PodSpec{Containers: []corev1.Container{envFrom("db-config")}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &PodInfo{Name: "web-1", StatefulSet: "web", Ordinal: 1}
+			if err := pod.CheckNames(original, tt.mutated); (err != nil) != tt.wantErr {
+				t.Errorf("CheckNames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPodInfo_ExpandText(t *testing.T) {
+	pod := &PodInfo{Name: "kafka-1", StatefulSet: "kafka", Ordinal: 1}
+	got, err := pod.ExpandText("https://auth.example.com/{{.StatefulSet}}/{{.Ordinal}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://auth.example.com/kafka/1"; got != want {
+		t.Errorf("ExpandText() got = %v, want %v", got, want)
+	}
+	if _, err := pod.ExpandText("{{.Broker}}"); err == nil {
+		t.Error("ExpandText() expects an error for an unknown field")
+	}
+}
@@ -0,0 +1,185 @@
+package annotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DefaultPriority of a handler not implementing Prioritized
+const DefaultPriority = 100
+
+// Prioritized is optionally implemented by a Handler to be applied before handlers of higher priority
+type Prioritized interface {
+	Priority() int
+}
+
+// Dependent is optionally implemented by a Handler to be applied after the handlers of the names it depends on
+type Dependent interface {
+	DependsOn() []string
+}
+
+// HandlerInfo describes a handler of a registry
+type HandlerInfo struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Priority  int      `json:"priority"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+	Enabled   bool     `json:"enabled"`
+}
+
+// Registry orders named handlers by dependencies then priority, and enables or disables them by name
+type Registry struct {
+	handlers []Handler
+	infos    []*HandlerInfo
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds an enabled handler, which must implement Named with a name not registered yet
+func (r *Registry) Register(h Handler) error {
+	n, ok := h.(Named)
+	if !ok {
+		return fmt.Errorf("handler %T doesn't declare its annotation name", h)
+	}
+	if r.info(n.Name()) != nil {
+		return fmt.Errorf("handler %s is already registered", n.Name())
+	}
+	info := &HandlerInfo{Name: n.Name(), Type: fmt.Sprintf("%T", h), Priority: DefaultPriority, Enabled: true}
+	if p, ok := h.(Prioritized); ok {
+		info.Priority = p.Priority()
+	}
+	if d, ok := h.(Dependent); ok {
+		info.DependsOn = d.DependsOn()
+	}
+	r.handlers = append(r.handlers, h)
+	r.infos = append(r.infos, info)
+	return nil
+}
+
+func (r *Registry) info(name string) *HandlerInfo {
+	for _, i := range r.infos {
+		if i.Name == name {
+			return i
+		}
+	}
+	return nil
+}
+
+// SetEnabled enables or disables the handlers of the names
+func (r *Registry) SetEnabled(enabled bool, names ...string) error {
+	if err := r.checkNames(names); err != nil {
+		return err
+	}
+	for _, n := range names {
+		r.info(n).Enabled = enabled
+	}
+	return nil
+}
+
+// EnableOnly enables the handlers of the names and disables all the others
+func (r *Registry) EnableOnly(names ...string) error {
+	if err := r.checkNames(names); err != nil {
+		return err
+	}
+	for _, i := range r.infos {
+		i.Enabled = false
+	}
+	return r.SetEnabled(true, names...)
+}
+
+// Configure enables only the handlers of enabled, all of them when empty, then disables the handlers of disabled
+func (r *Registry) Configure(enabled, disabled []string) error {
+	if len(enabled) > 0 {
+		if err := r.EnableOnly(enabled...); err != nil {
+			return err
+		}
+	}
+	return r.SetEnabled(false, disabled...)
+}
+
+func (r *Registry) checkNames(names []string) error {
+	for _, n := range names {
+		if r.info(n) == nil {
+			return fmt.Errorf("unknown handler %s", n)
+		}
+	}
+	return nil
+}
+
+// Handlers returns the enabled handlers, each after the handlers it depends on, then by priority and registration
+// order. It fails when a dependency is unknown, disabled or cyclic
+func (r *Registry) Handlers() ([]Handler, error) {
+	pending := map[int]bool{}
+	for i, info := range r.infos {
+		if !info.Enabled {
+			continue
+		}
+		for _, d := range info.DependsOn {
+			di := r.info(d)
+			if di == nil {
+				return nil, fmt.Errorf("handler %s depends on unknown handler %s", info.Name, d)
+			}
+			if !di.Enabled {
+				return nil, fmt.Errorf("handler %s depends on disabled handler %s", info.Name, d)
+			}
+		}
+		pending[i] = true
+	}
+	applied := map[string]bool{}
+	var ordered []Handler
+	for len(pending) > 0 {
+		next := -1
+		for i := range pending {
+			if !r.ready(i, applied) {
+				continue
+			}
+			if next == -1 || r.infos[i].Priority < r.infos[next].Priority ||
+				(r.infos[i].Priority == r.infos[next].Priority && i < next) {
+				next = i
+			}
+		}
+		if next == -1 {
+			var cycle []string
+			for i := range pending {
+				cycle = append(cycle, r.infos[i].Name)
+			}
+			sort.Strings(cycle)
+			return nil, fmt.Errorf("cyclic dependencies between handlers %s", strings.Join(cycle, ", "))
+		}
+		delete(pending, next)
+		applied[r.infos[next].Name] = true
+		ordered = append(ordered, r.handlers[next])
+	}
+	return ordered, nil
+}
+
+func (r *Registry) ready(i int, applied map[string]bool) bool {
+	for _, d := range r.infos[i].DependsOn {
+		if !applied[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// Infos describes the registered handlers, in registration order
+func (r *Registry) Infos() []HandlerInfo {
+	infos := make([]HandlerInfo, 0, len(r.infos))
+	for _, i := range r.infos {
+		infos = append(infos, *i)
+	}
+	return infos
+}
+
+// ServeHTTP exposes the registered handlers as JSON, for debugging
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(r.Infos()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,183 @@
+package annotation
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type registeredHandler struct {
+	name      string
+	priority  int
+	dependsOn []string
+}
+
+func (h *registeredHandler) Mutate(*corev1.PodSpec, int, interface{}) error {
+	return nil
+}
+
+func (h *registeredHandler) GetParser() Parser {
+	return nil
+}
+
+func (h *registeredHandler) Name() string {
+	return h.name
+}
+
+func (h *registeredHandler) Priority() int {
+	return h.priority
+}
+
+func (h *registeredHandler) DependsOn() []string {
+	return h.dependsOn
+}
+
+func TestRegistry_Handlers(t *testing.T) {
+	tests := []struct {
+		name     string
+		handlers []*registeredHandler
+		disabled []string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "priority then registration order",
+			handlers: []*registeredHandler{
+				{name: "a", priority: 100},
+				{name: "b", priority: 10},
+				{name: "c", priority: 100},
+			},
+			want: []string{"b", "a", "c"},
+		},
+		{
+			name: "dependencies first",
+			handlers: []*registeredHandler{
+				{name: "a", priority: 10, dependsOn: []string{"c"}},
+				{name: "b", priority: 20},
+				{name: "c", priority: 30},
+			},
+			want: []string{"b", "c", "a"},
+		},
+		{
+			name: "disabled handler",
+			handlers: []*registeredHandler{
+				{name: "a"},
+				{name: "b"},
+			},
+			disabled: []string{"a"},
+			want:     []string{"b"},
+		},
+		{
+			name: "disabled dependency",
+			handlers: []*registeredHandler{
+				{name: "a", dependsOn: []string{"b"}},
+				{name: "b"},
+			},
+			disabled: []string{"b"},
+			wantErr:  true,
+		},
+		{
+			name: "unknown dependency",
+			handlers: []*registeredHandler{
+				{name: "a", dependsOn: []string{"b"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "cyclic dependencies",
+			handlers: []*registeredHandler{
+				{name: "a", dependsOn: []string{"b"}},
+				{name: "b", dependsOn: []string{"a"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			for _, h := range tt.handlers {
+				if err := r.Register(h); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.SetEnabled(false, tt.disabled...); err != nil {
+				t.Fatal(err)
+			}
+			got, err := r.Handlers()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Handlers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, h := range got {
+				names = append(names, h.(Named).Name())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Handlers() got = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_Configure(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  []string
+		disabled []string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "all enabled", want: []string{"a", "b", "c"}},
+		{name: "enable only", enabled: []string{"a", "c"}, want: []string{"a", "c"}},
+		{name: "disable", disabled: []string{"b"}, want: []string{"a", "c"}},
+		{name: "enable only then disable", enabled: []string{"a", "c"}, disabled: []string{"c"}, want: []string{"a"}},
+		{name: "unknown enabled handler", enabled: []string{"d"}, wantErr: true},
+		{name: "unknown disabled handler", disabled: []string{"d"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			for _, n := range []string{"a", "b", "c"} {
+				if err := r.Register(&registeredHandler{name: n}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Configure(tt.enabled, tt.disabled); (err != nil) != tt.wantErr {
+				t.Fatalf("Configure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var names []string
+			for _, i := range r.Infos() {
+				if i.Enabled {
+					names = append(names, i.Name)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Configure() enabled = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register(&registeredHandler{name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(&registeredHandler{name: "a"}); err == nil {
+		t.Errorf("Register() expected error for duplicate name")
+	}
+	if err := r.EnableOnly("b"); err == nil {
+		t.Errorf("EnableOnly() expected error for unknown name")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/debug/handlers", nil))
+	want := `[{"name":"a","type":"*annotation.registeredHandler","priority":0,"enabled":true}]`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("ServeHTTP() got = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,185 @@
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	ZoneAssignment = "zone-assignment"
+)
+
+// ZoneLabel is the default node label of the zones
+const ZoneLabel = corev1.LabelZoneFailureDomainStable
+
+// Scheduling of a zone assignment
+type Scheduling string
+
+const (
+	// SchedulingStrict requires the node of the pod to be in its zone
+	SchedulingStrict Scheduling = "strict"
+	// SchedulingPreferred prefers a node in the zone of the pod
+	SchedulingPreferred Scheduling = "preferred"
+)
+
+// defaultWeight of a preferred zone assignment
+const defaultWeight = 100
+
+var log = logf.Log.WithName("zone_assignment")
+
+type zoneConfig struct {
+	qualifier string
+	cfg       *zoneConfigValue
+}
+
+type zoneConfigValue struct {
+	// Zones are assigned round-robin, ordinal N to Zones[N mod len(Zones)]
+	Zones []string `json:"zones"`
+	// Scheduling is strict by default
+	Scheduling Scheduling `json:"scheduling,omitempty"`
+	// TopologyKey is the node label of the zones, ZoneLabel by default
+	TopologyKey string `json:"topologyKey,omitempty"`
+	// Weight of a preferred assignment, 100 by default
+	Weight int32 `json:"weight,omitempty"`
+}
+
+// ZoneHandler spreads the pods of a StatefulSet over zones with node affinity, ordinal N being assigned to the
+// zone N modulo the number of zones
+type ZoneHandler struct {
+}
+
+func (h *ZoneHandler) Mutate(spec *corev1.PodSpec, ordinal int, cfg interface{}) error {
+	return h.MutatePod(spec, annotation.NewPodInfo(ordinal), cfg)
+}
+
+func (h *ZoneHandler) MutatePod(spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	ll := log.WithValues("ordinal", pod.Ordinal)
+	c, ok := cfg.([]zoneConfig)
+	if !ok {
+		return fmt.Errorf("unexpected config type %T", cfg)
+	}
+	// the most specific qualifying annotation wins, as a pod is in a single zone
+	var v *zoneConfigValue
+	for _, e := range c {
+		if annotation.CommonPodQualifier(pod.Ordinal, e.qualifier) {
+			v = e.cfg
+			break
+		}
+	}
+	if v == nil {
+		ll.Info("qualifier excludes this pod")
+		return nil
+	}
+	if pod.Ordinal < 0 {
+		return fmt.Errorf("%s annotation can't assign negative ordinal %d", ZoneAssignment, pod.Ordinal)
+	}
+	zone := v.Zones[pod.Ordinal%len(v.Zones)]
+	requirement := corev1.NodeSelectorRequirement{Key: v.TopologyKey, Operator: corev1.NodeSelectorOpIn, Values: []string{zone}}
+	ll.Info("assign zone", "zone", zone, "scheduling", v.Scheduling)
+	if spec.Affinity == nil {
+		spec.Affinity = &corev1.Affinity{}
+	}
+	if spec.Affinity.NodeAffinity == nil {
+		spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	a := spec.Affinity.NodeAffinity
+	if v.Scheduling == SchedulingPreferred {
+		a.PreferredDuringSchedulingIgnoredDuringExecution = append(a.PreferredDuringSchedulingIgnoredDuringExecution,
+			corev1.PreferredSchedulingTerm{
+				Weight:     v.Weight,
+				Preference: corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{requirement}},
+			})
+		return nil
+	}
+	if a.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		a.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	required := a.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	// terms are ORed, the zone is required by each of them
+	for i := range required.NodeSelectorTerms {
+		t := &required.NodeSelectorTerms[i]
+		t.MatchExpressions = append(t.MatchExpressions, requirement)
+	}
+	return nil
+}
+
+func (h *ZoneHandler) Name() string {
+	return ZoneAssignment
+}
+
+func (h *ZoneHandler) GetParser() annotation.Parser {
+	return zoneParser
+}
+
+var _ annotation.Handler = &ZoneHandler{}
+var _ annotation.Named = &ZoneHandler{}
+var _ annotation.PodHandler = &ZoneHandler{}
+
+// zoneParser parses all the zone-assignment annotations, ordered from the most specific qualifier. A value is either the list of zones or an object with the zones and the scheduling options
+var zoneParser annotation.ParserFunc = func(annotations map[annotation.QualifiedName]string) (interface{}, error) {
+	var c []zoneConfig
+	for k, v := range annotations {
+		if k.Name != ZoneAssignment {
+			continue
+		}
+		log.Info("parse config for zone assignment", "qualifiedName", k, "value", v)
+		value := &zoneConfigValue{}
+		if strings.HasPrefix(strings.TrimSpace(v), "[") {
+			if err := json.Unmarshal([]byte(v), &value.Zones); err != nil {
+				return nil, err
+			}
+		} else if err := json.Unmarshal([]byte(v), value); err != nil {
+			return nil, err
+		}
+		if err := value.complete(); err != nil {
+			return nil, err
+		}
+		c = append(c, zoneConfig{qualifier: k.Qualifier, cfg: value})
+	}
+	if c == nil {
+		return nil, nil
+	}
+	sort.Slice(c, func(i, j int) bool {
+		return annotation.LessQualifier(c[i].qualifier, c[j].qualifier)
+	})
+	return c, nil
+}
+
+// complete validates the value and sets its defaults
+func (v *zoneConfigValue) complete() error {
+	if len(v.Zones) == 0 {
+		return fmt.Errorf("%s annotation has no zone", ZoneAssignment)
+	}
+	for _, z := range v.Zones {
+		if z == "" {
+			return fmt.Errorf("%s annotation has an empty zone", ZoneAssignment)
+		}
+	}
+	switch v.Scheduling {
+	case "":
+		v.Scheduling = SchedulingStrict
+	case SchedulingStrict, SchedulingPreferred:
+	default:
+		return fmt.Errorf("%s annotation has unknown scheduling %q, expect %q or %q",
+			ZoneAssignment, v.Scheduling, SchedulingStrict, SchedulingPreferred)
+	}
+	if v.TopologyKey == "" {
+		v.TopologyKey = ZoneLabel
+	}
+	if v.Weight == 0 {
+		v.Weight = defaultWeight
+	}
+	if v.Weight < 1 || v.Weight > 100 {
+		return fmt.Errorf("%s annotation weight %d is not in 1-100", ZoneAssignment, v.Weight)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package scheduling
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestZoneHandler_MutatePod(t *testing.T) {
+	zone := func(key, z string) v1.NodeSelectorRequirement {
+		return v1.NodeSelectorRequirement{Key: key, Operator: v1.NodeSelectorOpIn, Values: []string{z}}
+	}
+	ssd := v1.NodeSelectorRequirement{Key: "disk", Operator: v1.NodeSelectorOpIn, Values: []string{"ssd"}}
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		ordinal     int
+		affinity    *v1.Affinity
+		want        *v1.Affinity
+	}{
+		{
+			name:        "strict round robin",
+			annotations: map[annotation.QualifiedName]string{{Name: ZoneAssignment}: `["a","b","c"]`},
+			ordinal:     4,
+			want: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+					NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: []v1.NodeSelectorRequirement{zone(ZoneLabel, "b")}}},
+				},
+			}},
+		},
+		{
+			name:        "strict added to each existing term",
+			annotations: map[annotation.QualifiedName]string{{Name: ZoneAssignment}: `["a","b","c"]`},
+			ordinal:     3,
+			affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+					NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: []v1.NodeSelectorRequirement{ssd}}, {}},
+				},
+			}},
+			want: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+					NodeSelectorTerms: []v1.NodeSelectorTerm{
+						{MatchExpressions: []v1.NodeSelectorRequirement{ssd, zone(ZoneLabel, "a")}},
+						{MatchExpressions: []v1.NodeSelectorRequirement{zone(ZoneLabel, "a")}},
+					},
+				},
+			}},
+		},
+		{
+			name: "preferred with options",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ZoneAssignment}: `{"zones":["eu-1","eu-2"],"scheduling":"preferred","topologyKey":"example.com/zone","weight":50}`,
+			},
+			ordinal: 1,
+			want: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{{
+					Weight:     50,
+					Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{zone("example.com/zone", "eu-2")}},
+				}},
+			}},
+		},
+		{
+			name: "most specific qualifying annotation wins",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ZoneAssignment, Qualifier: "2-"}: `["a","b"]`,
+				{Name: ZoneAssignment, Qualifier: "10"}: `{"zones":["c"],"scheduling":"preferred"}`,
+			},
+			ordinal: 10,
+			want: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{{
+					Weight:     100,
+					Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{zone(ZoneLabel, "c")}},
+				}},
+			}},
+		},
+		{
+			name:        "qualifier excludes ordinal",
+			annotations: map[annotation.QualifiedName]string{{Name: ZoneAssignment, Qualifier: "0"}: `["a","b"]`},
+			ordinal:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &ZoneHandler{}
+			cfg, err := h.GetParser().Parse(tt.annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec := &v1.PodSpec{Affinity: tt.affinity}
+			if err := h.MutatePod(spec, annotation.NewPodInfo(tt.ordinal), cfg); err != nil {
+				t.Fatalf("MutatePod() error = %v", err)
+			}
+			if !reflect.DeepEqual(spec.Affinity, tt.want) {
+				t.Errorf("MutatePod() affinity = %+v, want %+v", spec.Affinity, tt.want)
+			}
+		})
+	}
+}
+
+func Test_zoneParser_Parse(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		wantNil     bool
+		wantErr     bool
+	}{
+		{name: "no annotation", annotations: map[annotation.QualifiedName]string{{Name: "mount-volume"}: "{}"}, wantNil: true},
+		{name: "not json", annotations: map[annotation.QualifiedName]string{{Name: ZoneAssignment}: "a,b"}, wantErr: true},
+		{name: "no zone", annotations: map[annotation.QualifiedName]string{{Name: ZoneAssignment}: `[]`}, wantErr: true},
+		{name: "empty zone", annotations: map[annotation.QualifiedName]string{{Name: ZoneAssignment}: `["a",""]`}, wantErr: true},
+		{name: "unknown scheduling", annotations: map[annotation.QualifiedName]string{{Name: ZoneAssignment}: `{"zones":["a"],"scheduling":"soft"}`}, wantErr: true},
+		{name: "invalid weight", annotations: map[annotation.QualifiedName]string{{Name: ZoneAssignment}: `{"zones":["a"],"weight":200}`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := zoneParser.Parse(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.wantNil || tt.wantErr) {
+				t.Errorf("Parse() got = %v", got)
+			}
+		})
+	}
+}
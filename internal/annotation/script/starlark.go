@@ -0,0 +1,255 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/spoditor/spoditor/internal/annotation"
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	Script = "script"
+)
+
+// MutateFunction is the function a script defines, called with the pod info and the pod spec
+const MutateFunction = "mutate"
+
+const (
+	// DefaultMaxSteps bounds the computation of a script evaluation
+	DefaultMaxSteps = 1000000
+	// DefaultTimeout bounds the duration of a script evaluation, short enough to answer the admission request in time
+	DefaultTimeout = time.Second
+)
+
+var log = logf.Log.WithName("script")
+
+// predeclared are the only names a script can use besides the Starlark built-ins. Without load, a script can't
+// read files or reach the network
+var predeclared = starlark.StringDict{
+	"json": starlarkjson.Module,
+}
+
+type scriptConfig []scriptConfigEntry
+
+type scriptConfigEntry struct {
+	qualifier string
+	program   *starlark.Program
+}
+
+// StarlarkHandler evaluates the Starlark script of the spoditor.io/script annotation, whose mutate(pod, spec)
+// function returns the JSON patch of the pod spec, for the one-off mutations no other handler covers
+type StarlarkHandler struct {
+	// StatefulSets is optional, when set the replicas of the StatefulSet are given to the script
+	StatefulSets client.Reader
+	// MaxSteps is DefaultMaxSteps when 0
+	MaxSteps uint64
+	// Timeout is DefaultTimeout when 0
+	Timeout time.Duration
+}
+
+func (h *StarlarkHandler) Mutate(spec *corev1.PodSpec, ordinal int, cfg interface{}) error {
+	return h.MutatePod(spec, annotation.NewPodInfo(ordinal), cfg)
+}
+
+func (h *StarlarkHandler) MutatePod(spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	return h.MutatePodContext(context.Background(), spec, pod, cfg)
+}
+
+// MutatePodContext evaluates every script qualifying the pod
+func (h *StarlarkHandler) MutatePodContext(ctx context.Context, spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	ll := log.WithValues("ordinal", pod.Ordinal)
+	c, ok := cfg.(scriptConfig)
+	if !ok {
+		return fmt.Errorf("unexpected config type %T", cfg)
+	}
+	var replicas starlark.Value = starlark.None
+	looked := false
+	// the most specific script is evaluated last, patching the spec patched by the others
+	for i := len(c) - 1; i >= 0; i-- {
+		e := c[i]
+		if !annotation.CommonPodQualifier(pod.Ordinal, e.qualifier) {
+			ll.Info("qualifier excludes this pod", "qualifier", e.qualifier)
+			continue
+		}
+		if !looked {
+			r, err := h.replicas(ctx, pod)
+			if err != nil {
+				return err
+			}
+			replicas, looked = r, true
+		}
+		ll.Info("evaluate script", "qualifier", e.qualifier)
+		if err := h.eval(ctx, e.program, spec, pod, replicas); err != nil {
+			return fmt.Errorf("%s annotation %q: %v", Script, e.qualifier, err)
+		}
+	}
+	return nil
+}
+
+// eval runs the script in a thread limited in steps and time, canceled with the admission request
+func (h *StarlarkHandler) eval(ctx context.Context, program *starlark.Program, spec *corev1.PodSpec, pod *annotation.PodInfo, replicas starlark.Value) error {
+	maxSteps := h.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = DefaultMaxSteps
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	thread := &starlark.Thread{
+		Name:  Script,
+		Print: func(_ *starlark.Thread, msg string) { log.Info("script output", "message", msg) },
+	}
+	thread.SetMaxExecutionSteps(maxSteps)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	globals, err := program.Init(thread, predeclared)
+	if err != nil {
+		return err
+	}
+	mutate, ok := globals[MutateFunction].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("script doesn't define a %s function", MutateFunction)
+	}
+	info, err := decode(thread, pod)
+	if err != nil {
+		return err
+	}
+	info.(*starlark.Dict).SetKey(starlark.String("replicas"), replicas)
+	s, err := decode(thread, spec)
+	if err != nil {
+		return err
+	}
+	result, err := starlark.Call(thread, mutate, starlark.Tuple{info, s}, nil)
+	if err != nil {
+		return err
+	}
+	if result == starlark.None {
+		return nil
+	}
+	encoded, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{result}, nil)
+	if err != nil {
+		return fmt.Errorf("%s returned a value which is not JSON: %v", MutateFunction, err)
+	}
+	patch, err := jsonpatch.DecodePatch([]byte(encoded.(starlark.String)))
+	if err != nil {
+		return fmt.Errorf("%s returned an invalid JSON patch: %v", MutateFunction, err)
+	}
+	original, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	patched, err := patch.Apply(original)
+	if err != nil {
+		return fmt.Errorf("failed to apply the patch returned by %s: %v", MutateFunction, err)
+	}
+	mutated := &corev1.PodSpec{}
+	if err := json.Unmarshal(patched, mutated); err != nil {
+		return fmt.Errorf("invalid pod spec patched by %s: %v", MutateFunction, err)
+	}
+	if err := pod.CheckNames(spec, mutated); err != nil {
+		return fmt.Errorf("patch returned by %s: %v", MutateFunction, err)
+	}
+	*spec = *mutated
+	return nil
+}
+
+// decode converts a Go value to Starlark through JSON
+func decode(thread *starlark.Thread, v interface{}) (starlark.Value, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Call(thread, starlarkjson.Module.Members["decode"], starlark.Tuple{starlark.String(b)}, nil)
+}
+
+// replicas of the StatefulSet of the pod, None when unknown
+func (h *StarlarkHandler) replicas(ctx context.Context, pod *annotation.PodInfo) (starlark.Value, error) {
+	if h.StatefulSets == nil || pod.StatefulSet == "" {
+		return starlark.None, nil
+	}
+	ss := &appsv1.StatefulSet{}
+	if err := h.StatefulSets.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: pod.StatefulSet}, ss); err != nil {
+		if apierrors.IsNotFound(err) {
+			return starlark.None, nil
+		}
+		return nil, fmt.Errorf("failed to get statefulset %s: %v", pod.StatefulSet, err)
+	}
+	if ss.Spec.Replicas == nil {
+		return starlark.MakeInt(1), nil
+	}
+	return starlark.MakeInt(int(*ss.Spec.Replicas)), nil
+}
+
+func (h *StarlarkHandler) Name() string {
+	return Script
+}
+
+func (h *StarlarkHandler) GetParser() annotation.Parser {
+	return scriptParser
+}
+
+var _ annotation.Handler = &StarlarkHandler{}
+var _ annotation.Named = &StarlarkHandler{}
+var _ annotation.PodHandler = &StarlarkHandler{}
+var _ annotation.ContextPodHandler = &StarlarkHandler{}
+var _ annotation.Merging = &StarlarkHandler{}
+var _ annotation.Prioritized = &StarlarkHandler{}
+
+// Priority of scripts, applied after the handlers of the default priority so that they see the spec those mutated
+func (h *StarlarkHandler) Priority() int {
+	return 2 * annotation.DefaultPriority
+}
+
+func (h *StarlarkHandler) MergesAnnotations() bool {
+	return true
+}
+
+// scriptParser compiles all the script annotations, ordered from the most specific qualifier.
+// Scripts are compiled without load, while loops and recursion, so that their evaluation is bounded by the steps limit
+var scriptParser annotation.ParserFunc = func(annotations map[annotation.QualifiedName]string) (interface{}, error) {
+	var c scriptConfig
+	for k, v := range annotations {
+		if k.Name != Script {
+			continue
+		}
+		log.Info("compile script", "qualifiedName", k)
+		_, program, err := starlark.SourceProgramOptions(&syntax.FileOptions{}, annotation.DefaultSyntax.Key(k), v, predeclared.Has)
+		if err != nil {
+			return nil, fmt.Errorf("%s annotation can't be compiled: %v", Script, err)
+		}
+		if program.NumLoads() > 0 {
+			return nil, fmt.Errorf("%s annotation can't load other modules", Script)
+		}
+		c = append(c, scriptConfigEntry{qualifier: k.Qualifier, program: program})
+	}
+	if c == nil {
+		return nil, nil
+	}
+	sort.Slice(c, func(i, j int) bool {
+		return annotation.LessQualifier(c[i].qualifier, c[j].qualifier)
+	})
+	return c, nil
+}
@@ -0,0 +1,201 @@
+package script
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const envScript = `
+def mutate(pod, spec):
+    value = "primary" if pod["ordinal"] == 0 else "replica-%d-of-%d" % (pod["ordinal"], pod["replicas"])
+    return [{"op": "add", "path": "/containers/0/env", "value": [{"name": "ROLE", "value": value}]}]
+`
+
+func TestStarlarkHandler_MutatePod(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	replicas := int32(3)
+	statefulSets := fake.NewFakeClientWithScheme(s, &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+	})
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		ordinal     int
+		maxSteps    uint64
+		timeout     time.Duration
+		want        []corev1.EnvVar
+		wantErr     bool
+	}{
+		{
+			name:        "script of the ordinal",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: envScript},
+			ordinal:     2,
+			want:        []corev1.EnvVar{{Name: "ROLE", Value: "replica-2-of-3"}},
+		},
+		{
+			name:        "qualifier excludes the pod",
+			annotations: map[annotation.QualifiedName]string{{Name: Script, Qualifier: "1-"}: envScript},
+			ordinal:     0,
+		},
+		{
+			name: "most specific script evaluated last",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: Script, Qualifier: "0-2"}: envScript,
+				{Name: Script, Qualifier: "0"}: `
+def mutate(pod, spec):
+    return [{"op": "add", "path": "/containers/0/env/-", "value": {"name": "STS", "value": pod["statefulSet"]}}]
+`,
+			},
+			ordinal: 0,
+			want:    []corev1.EnvVar{{Name: "ROLE", Value: "primary"}, {Name: "STS", Value: "web"}},
+		},
+		{
+			name: "no patch",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `
+def mutate(pod, spec):
+    return None
+`},
+		},
+		{
+			name:        "missing mutate function",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `x = 1`},
+			wantErr:     true,
+		},
+		{
+			name: "invalid patch",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `
+def mutate(pod, spec):
+    return [{"op": "remove", "path": "/volumes/0"}]
+`},
+			wantErr: true,
+		},
+		{
+			name: "secret of the statefulset",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `
+def mutate(pod, spec):
+    return [{"op": "add", "path": "/containers/0/env", "value": [{"name": "ROLE", "value": "primary"}]},
+            {"op": "add", "path": "/volumes", "value": [{"name": "tls", "secret": {"secretName": "web-tls-0"}}]}]
+`},
+			want: []corev1.EnvVar{{Name: "ROLE", Value: "primary"}},
+		},
+		{
+			name: "secret of another statefulset",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `
+def mutate(pod, spec):
+    return [{"op": "add", "path": "/volumes", "value": [{"name": "tls", "secret": {"secretName": "db-tls-0"}}]}]
+`},
+			wantErr: true,
+		},
+		{
+			name: "steps limit",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `
+def mutate(pod, spec):
+    n = 0
+    for i in range(1000000):
+        n += i
+    return None
+`},
+			maxSteps: 1000,
+			wantErr:  true,
+		},
+		{
+			name: "timeout",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `
+def mutate(pod, spec):
+    n = 0
+    for i in range(100000000):
+        n += i
+    return None
+`},
+			maxSteps: 1 << 62,
+			timeout:  50 * time.Millisecond,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &StarlarkHandler{StatefulSets: statefulSets, MaxSteps: tt.maxSteps, Timeout: tt.timeout}
+			cfg, err := h.GetParser().Parse(tt.annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := annotation.NewPodInfo(tt.ordinal)
+			pod.Namespace = "default"
+			pod.StatefulSet = "web"
+			spec := &corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx"}}}
+			err = h.MutatePodContext(context.TODO(), spec, pod, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MutatePodContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(spec.Containers[0].Env, tt.want) {
+				t.Errorf("MutatePodContext() env = %v, want %v", spec.Containers[0].Env, tt.want)
+			}
+		})
+	}
+}
+
+func Test_scriptParser_Parse(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		wantNil     bool
+		wantErr     bool
+	}{
+		{
+			name:        "other annotations",
+			annotations: map[annotation.QualifiedName]string{{Name: "mount-volume"}: "{}"},
+			wantNil:     true,
+		},
+		{
+			name:        "valid script",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: envScript},
+		},
+		{
+			name:        "syntax error",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `def mutate(pod, spec)`},
+			wantErr:     true,
+		},
+		{
+			name: "while loop",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `
+def mutate(pod, spec):
+    while True:
+        pass
+`},
+			wantErr: true,
+		},
+		{
+			name:        "load",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `load("other.star", "x")`},
+			wantErr:     true,
+		},
+		{
+			name:        "undefined name",
+			annotations: map[annotation.QualifiedName]string{{Name: Script}: `x = os.environ`},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scriptParser.Parse(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (got == nil) != tt.wantNil {
+				t.Errorf("Parse() = %v, want nil %v", got, tt.wantNil)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package security
+
+import (
+	"fmt"
+	"sort"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	SecurityContext = "security-context"
+)
+
+// AllNamespaces in the escalation namespaces allows escalations in every namespace
+const AllNamespaces = "*"
+
+var log = logf.Log.WithName("security_context")
+
+type contextConfig []contextConfigEntry
+
+type contextConfigEntry struct {
+	qualifier string
+	cfg       *contextConfigValue
+}
+
+type contextConfigValue struct {
+	// Pod fields are merged into the pod securityContext
+	Pod *corev1.PodSecurityContext `json:"pod,omitempty"`
+	// Containers fields are merged into the securityContext of the containers and init containers of the same name
+	Containers []containerContext `json:"containers,omitempty"`
+	// hostProcess are the fields setting windowsOptions.hostProcess, which the Kubernetes API types of spoditor
+	// predate and would drop
+	hostProcess []string
+}
+
+// hostProcessValue reads the windowsOptions.hostProcess fields of a contextConfigValue
+type hostProcessValue struct {
+	Pod *struct {
+		WindowsOptions *hostProcessOptions `json:"windowsOptions"`
+	} `json:"pod"`
+	Containers []struct {
+		Name            string `json:"name"`
+		SecurityContext *struct {
+			WindowsOptions *hostProcessOptions `json:"windowsOptions"`
+		} `json:"securityContext"`
+	} `json:"containers"`
+}
+
+type hostProcessOptions struct {
+	HostProcess *bool `json:"hostProcess"`
+}
+
+func (o *hostProcessOptions) enabled() bool {
+	return o != nil && o.HostProcess != nil && *o.HostProcess
+}
+
+type containerContext struct {
+	Name            string                  `json:"name"`
+	SecurityContext *corev1.SecurityContext `json:"securityContext"`
+}
+
+// ContextHandler merges per-ordinal overrides into the pod and container securityContext, the fields set by the
+// annotation replacing the ones of the pod template. Overrides escalating privileges are rejected unless the pod
+// namespace is one of EscalationNamespaces
+type ContextHandler struct {
+	// EscalationNamespaces are the namespaces allowed to escalate privileges, AllNamespaces for all of them
+	EscalationNamespaces []string
+}
+
+func (h *ContextHandler) Mutate(spec *corev1.PodSpec, ordinal int, cfg interface{}) error {
+	return h.MutatePod(spec, annotation.NewPodInfo(ordinal), cfg)
+}
+
+func (h *ContextHandler) MutatePod(spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	ll := log.WithValues("ordinal", pod.Ordinal)
+	c, ok := cfg.(contextConfig)
+	if !ok {
+		return fmt.Errorf("unexpected config type %T", cfg)
+	}
+	// the most specific annotation is merged last, its fields replacing those of the others
+	for i := len(c) - 1; i >= 0; i-- {
+		e := c[i]
+		if !annotation.CommonPodQualifier(pod.Ordinal, e.qualifier) {
+			ll.Info("qualifier excludes this pod", "qualifier", e.qualifier)
+			continue
+		}
+		if escalations := e.cfg.escalations(); len(escalations) > 0 && !h.allowsEscalation(pod.Namespace) {
+			return fmt.Errorf("%s annotation escalates privileges with %v, not allowed in namespace %q",
+				SecurityContext, escalations, pod.Namespace)
+		}
+		if len(e.cfg.hostProcess) > 0 {
+			return fmt.Errorf("%s annotation sets %v, not supported by this version of spoditor",
+				SecurityContext, e.cfg.hostProcess)
+		}
+		if e.cfg.Pod != nil {
+			if spec.SecurityContext == nil {
+				spec.SecurityContext = &corev1.PodSecurityContext{}
+			}
+			if err := merge(spec.SecurityContext, e.cfg.Pod); err != nil {
+				return err
+			}
+			ll.Info("merge pod securityContext")
+		}
+		for _, cc := range e.cfg.Containers {
+			containers := containersNamed(spec, cc.Name)
+			if len(containers) == 0 {
+				return fmt.Errorf("%s annotation container %s not found", SecurityContext, cc.Name)
+			}
+			for _, container := range containers {
+				if container.SecurityContext == nil {
+					container.SecurityContext = &corev1.SecurityContext{}
+				}
+				if err := merge(container.SecurityContext, cc.SecurityContext); err != nil {
+					return err
+				}
+				ll.Info("merge container securityContext", "container", cc.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *ContextHandler) allowsEscalation(namespace string) bool {
+	for _, n := range h.EscalationNamespaces {
+		if n == AllNamespaces || n == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// escalations lists the fields of the overrides escalating privileges
+func (v *contextConfigValue) escalations() []string {
+	var e []string
+	if p := v.Pod; p != nil {
+		e = append(e, rootEscalations("pod", p.RunAsUser, p.RunAsGroup, p.RunAsNonRoot)...)
+		e = append(e, confinementEscalations("pod", p.SeccompProfile, p.SELinuxOptions)...)
+	}
+	for _, cc := range v.Containers {
+		s := cc.SecurityContext
+		if s == nil {
+			continue
+		}
+		field := "container " + cc.Name
+		if s.Privileged != nil && *s.Privileged {
+			e = append(e, field+" privileged")
+		}
+		if s.AllowPrivilegeEscalation != nil && *s.AllowPrivilegeEscalation {
+			e = append(e, field+" allowPrivilegeEscalation")
+		}
+		if s.Capabilities != nil && len(s.Capabilities.Add) > 0 {
+			e = append(e, field+" capabilities.add")
+		}
+		if s.ProcMount != nil && *s.ProcMount == corev1.UnmaskedProcMount {
+			e = append(e, field+" procMount")
+		}
+		e = append(e, rootEscalations(field, s.RunAsUser, s.RunAsGroup, s.RunAsNonRoot)...)
+		e = append(e, confinementEscalations(field, s.SeccompProfile, s.SELinuxOptions)...)
+	}
+	return append(e, v.hostProcess...)
+}
+
+// confinementEscalations lifts the seccomp confinement or relabels the SELinux context
+func confinementEscalations(field string, seccomp *corev1.SeccompProfile, seLinux *corev1.SELinuxOptions) []string {
+	var e []string
+	if seccomp != nil && seccomp.Type == corev1.SeccompProfileTypeUnconfined {
+		e = append(e, field+" seccompProfile")
+	}
+	if seLinux != nil {
+		e = append(e, field+" seLinuxOptions")
+	}
+	return e
+}
+
+func rootEscalations(field string, user, group *int64, nonRoot *bool) []string {
+	var e []string
+	if user != nil && *user == 0 {
+		e = append(e, field+" runAsUser")
+	}
+	if group != nil && *group == 0 {
+		e = append(e, field+" runAsGroup")
+	}
+	if nonRoot != nil && !*nonRoot {
+		e = append(e, field+" runAsNonRoot")
+	}
+	return e
+}
+
+// merge applies the fields set in override to target as a JSON merge patch
+func merge(target, override interface{}) error {
+	original, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	merged, err := jsonpatch.MergePatch(original, patch)
+	if err != nil {
+		return fmt.Errorf("failed to merge securityContext: %v", err)
+	}
+	return json.Unmarshal(merged, target)
+}
+
+// containersNamed returns the containers and init containers of the name
+func containersNamed(spec *corev1.PodSpec, name string) []*corev1.Container {
+	var containers []*corev1.Container
+	for i := range spec.InitContainers {
+		if spec.InitContainers[i].Name == name {
+			containers = append(containers, &spec.InitContainers[i])
+		}
+	}
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			containers = append(containers, &spec.Containers[i])
+		}
+	}
+	return containers
+}
+
+func (h *ContextHandler) Name() string {
+	return SecurityContext
+}
+
+func (h *ContextHandler) GetParser() annotation.Parser {
+	return contextParser
+}
+
+var _ annotation.Handler = &ContextHandler{}
+var _ annotation.Named = &ContextHandler{}
+var _ annotation.PodHandler = &ContextHandler{}
+var _ annotation.Merging = &ContextHandler{}
+
+func (h *ContextHandler) MergesAnnotations() bool {
+	return true
+}
+
+// contextParser parses all the security-context annotations, ordered from the most specific qualifier
+var contextParser annotation.ParserFunc = func(annotations map[annotation.QualifiedName]string) (interface{}, error) {
+	var c contextConfig
+	for k, v := range annotations {
+		if k.Name != SecurityContext {
+			continue
+		}
+		log.Info("parse config for security context", "qualifiedName", k, "value", v)
+		value := &contextConfigValue{}
+		if err := json.Unmarshal([]byte(v), value); err != nil {
+			return nil, err
+		}
+		hostProcess := &hostProcessValue{}
+		if err := json.Unmarshal([]byte(v), hostProcess); err != nil {
+			return nil, err
+		}
+		if p := hostProcess.Pod; p != nil && p.WindowsOptions.enabled() {
+			value.hostProcess = append(value.hostProcess, "pod windowsOptions.hostProcess")
+		}
+		for _, cc := range hostProcess.Containers {
+			if cc.SecurityContext != nil && cc.SecurityContext.WindowsOptions.enabled() {
+				value.hostProcess = append(value.hostProcess, "container "+cc.Name+" windowsOptions.hostProcess")
+			}
+		}
+		for _, cc := range value.Containers {
+			if cc.Name == "" {
+				return nil, fmt.Errorf("%s annotation has a container without name", SecurityContext)
+			}
+			if cc.SecurityContext == nil {
+				return nil, fmt.Errorf("%s annotation container %s has no securityContext", SecurityContext, cc.Name)
+			}
+		}
+		c = append(c, contextConfigEntry{qualifier: k.Qualifier, cfg: value})
+	}
+	if c == nil {
+		return nil, nil
+	}
+	sort.Slice(c, func(i, j int) bool {
+		return annotation.LessQualifier(c[i].qualifier, c[j].qualifier)
+	})
+	return c, nil
+}
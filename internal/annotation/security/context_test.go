@@ -0,0 +1,221 @@
+package security
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestContextHandler_MutatePod(t *testing.T) {
+	int64p := func(i int64) *int64 { return &i }
+	boolp := func(b bool) *bool { return &b }
+	newSpec := func() *v1.PodSpec {
+		return &v1.PodSpec{
+			SecurityContext: &v1.PodSecurityContext{RunAsUser: int64p(1000), FSGroup: int64p(1000)},
+			InitContainers:  []v1.Container{{Name: "init"}},
+			Containers: []v1.Container{{
+				Name: "web",
+				SecurityContext: &v1.SecurityContext{
+					ReadOnlyRootFilesystem: boolp(true),
+					Capabilities:           &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+				},
+			}},
+		}
+	}
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		namespaces  []string
+		ordinal     int
+		want        func(*v1.PodSpec)
+		wantErr     bool
+	}{
+		{
+			name: "pod fields merged",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext, Qualifier: "1"}: `{"pod":{"fsGroup":2000,"runAsGroup":3000}}`,
+			},
+			ordinal: 1,
+			want: func(s *v1.PodSpec) {
+				s.SecurityContext.FSGroup = int64p(2000)
+				s.SecurityContext.RunAsGroup = int64p(3000)
+			},
+		},
+		{
+			name: "container fields merged, init container context added",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"containers":[
+					{"name":"web","securityContext":{"runAsUser":2000}},
+					{"name":"init","securityContext":{"readOnlyRootFilesystem":false}}
+				]}`,
+			},
+			ordinal: 1,
+			want: func(s *v1.PodSpec) {
+				s.Containers[0].SecurityContext.RunAsUser = int64p(2000)
+				s.InitContainers[0].SecurityContext = &v1.SecurityContext{ReadOnlyRootFilesystem: boolp(false)}
+			},
+		},
+		{
+			name: "most specific qualifier merged last",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext, Qualifier: "0-"}: `{"pod":{"fsGroup":2000,"runAsNonRoot":true}}`,
+				{Name: SecurityContext, Qualifier: "10"}: `{"pod":{"fsGroup":3000}}`,
+				{Name: SecurityContext, Qualifier: "2-"}: `{"pod":{"fsGroup":4000}}`,
+			},
+			ordinal: 10,
+			want: func(s *v1.PodSpec) {
+				s.SecurityContext.FSGroup = int64p(3000)
+				s.SecurityContext.RunAsNonRoot = boolp(true)
+			},
+		},
+		{
+			name: "escalation forbidden",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"containers":[{"name":"web","securityContext":{"privileged":true}}]}`,
+			},
+			namespaces: []string{"maintenance"},
+			ordinal:    1,
+			wantErr:    true,
+		},
+		{
+			name: "root user forbidden",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"pod":{"runAsUser":0}}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+		{
+			name: "unconfined seccomp profile forbidden",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"pod":{"seccompProfile":{"type":"Unconfined"}}}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+		{
+			name: "seLinuxOptions forbidden",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"containers":[{"name":"web","securityContext":{"seLinuxOptions":{"type":"spc_t"}}}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+		{
+			name: "host process forbidden",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"containers":[{"name":"web","securityContext":{"windowsOptions":{"hostProcess":true}}}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+		{
+			name: "host process unsupported in escalation namespace",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"pod":{"windowsOptions":{"hostProcess":true}}}`,
+			},
+			namespaces: []string{AllNamespaces},
+			ordinal:    1,
+			wantErr:    true,
+		},
+		{
+			name: "runtime default seccomp profile allowed",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"pod":{"seccompProfile":{"type":"RuntimeDefault"}}}`,
+			},
+			ordinal: 1,
+			want: func(s *v1.PodSpec) {
+				s.SecurityContext.SeccompProfile = &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault}
+			},
+		},
+		{
+			name: "seLinuxOptions allowed in namespace",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"pod":{"seLinuxOptions":{"level":"s0:c123,c456"}}}`,
+			},
+			namespaces: []string{"default"},
+			ordinal:    1,
+			want: func(s *v1.PodSpec) {
+				s.SecurityContext.SELinuxOptions = &v1.SELinuxOptions{Level: "s0:c123,c456"}
+			},
+		},
+		{
+			name: "escalation allowed in namespace",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"containers":[{"name":"web","securityContext":{"capabilities":{"add":["SYS_ADMIN"]}}}]}`,
+			},
+			namespaces: []string{"kube-system", "default"},
+			ordinal:    1,
+			want: func(s *v1.PodSpec) {
+				s.Containers[0].SecurityContext.Capabilities.Add = []v1.Capability{"SYS_ADMIN"}
+			},
+		},
+		{
+			name: "escalation excluded by qualifier",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext, Qualifier: "0"}: `{"containers":[{"name":"web","securityContext":{"privileged":true}}]}`,
+			},
+			ordinal: 1,
+			want:    func(s *v1.PodSpec) {},
+		},
+		{
+			name: "unknown container",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: SecurityContext}: `{"containers":[{"name":"db","securityContext":{"runAsUser":2000}}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &ContextHandler{EscalationNamespaces: tt.namespaces}
+			cfg, err := h.GetParser().Parse(tt.annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := annotation.NewPodInfo(tt.ordinal)
+			pod.Namespace = "default"
+			spec := newSpec()
+			if err := h.MutatePod(spec, pod, cfg); (err != nil) != tt.wantErr {
+				t.Fatalf("MutatePod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := newSpec()
+			tt.want(want)
+			if !reflect.DeepEqual(spec, want) {
+				t.Errorf("MutatePod() = %+v, want %+v", spec, want)
+			}
+		})
+	}
+}
+
+func Test_contextParser_Parse(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		wantNil     bool
+		wantErr     bool
+	}{
+		{name: "no annotation", annotations: map[annotation.QualifiedName]string{{Name: "mount-volume"}: "{}"}, wantNil: true},
+		{name: "not json", annotations: map[annotation.QualifiedName]string{{Name: SecurityContext}: "root"}, wantErr: true},
+		{name: "unknown type", annotations: map[annotation.QualifiedName]string{{Name: SecurityContext}: `{"pod":{"runAsUser":"root"}}`}, wantErr: true},
+		{name: "no container name", annotations: map[annotation.QualifiedName]string{{Name: SecurityContext}: `{"containers":[{"securityContext":{}}]}`}, wantErr: true},
+		{name: "no securityContext", annotations: map[annotation.QualifiedName]string{{Name: SecurityContext}: `{"containers":[{"name":"web"}]}`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := contextParser.Parse(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.wantNil || tt.wantErr) {
+				t.Errorf("Parse() got = %v", got)
+			}
+		})
+	}
+}
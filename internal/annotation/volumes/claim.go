@@ -0,0 +1,163 @@
+package volumes
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+)
+
+const (
+	ClaimVolume = "claim-volume"
+)
+
+type claimConfig []claimConfigEntry
+
+type claimConfigEntry struct {
+	qualifier string
+	cfg       *claimConfigValue
+}
+
+type claimConfigValue struct {
+	Volumes []claimVolume `json:"volumes"`
+}
+
+// claimVolume selects the persistent volume claim of a pod volume
+type claimVolume struct {
+	// Name of the pod volume to replace, e.g. the name of a volumeClaimTemplate
+	Name string `json:"name"`
+	// ClaimName is the claim of the ordinals missing from Claims, suffixed with -<ordinal> unless it is a name template
+	ClaimName string `json:"claimName,omitempty"`
+	// Claims are the claims by ordinal, named after the base name of ClaimName, or of the StatefulSet without it
+	Claims   map[string]string `json:"claims,omitempty"`
+	ReadOnly bool              `json:"readOnly,omitempty"`
+}
+
+// ClaimHandler replaces pod volumes with per-ordinal persistent volume claims, e.g. pre-provisioned from snapshots
+type ClaimHandler struct {
+}
+
+func (h *ClaimHandler) Mutate(spec *corev1.PodSpec, ordinal int, cfg interface{}) error {
+	return h.MutatePod(spec, annotation.NewPodInfo(ordinal), cfg)
+}
+
+func (h *ClaimHandler) MutatePod(spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	ll := log.WithValues("ordinal", pod.Ordinal)
+	c, ok := cfg.(claimConfig)
+	if !ok {
+		return fmt.Errorf("unexpected config type %T", cfg)
+	}
+	// the most specific annotation is applied last, its claims replacing those of the others
+	for i := len(c) - 1; i >= 0; i-- {
+		e := c[i]
+		if !should(pod.Ordinal, e.qualifier) {
+			ll.Info("qualifier excludes this pod", "qualifier", e.qualifier)
+			continue
+		}
+		for _, v := range e.cfg.Volumes {
+			claim, err := v.claimOf(pod)
+			if err != nil {
+				return err
+			}
+			if claim == "" {
+				ll.Info("no claim for this pod", "volume", v.Name)
+				continue
+			}
+			ll.Info("select claim", "volume", v.Name, "claim", claim)
+			setClaim(spec, v.Name, &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim, ReadOnly: v.ReadOnly})
+		}
+	}
+	return nil
+}
+
+// claimOf returns the claim of the pod, empty when there is none
+func (v *claimVolume) claimOf(pod *annotation.PodInfo) (string, error) {
+	if claim, ok := v.Claims[strconv.Itoa(pod.Ordinal)]; ok {
+		base := pod.StatefulSet
+		if v.ClaimName != "" {
+			base = pod.Base(v.ClaimName)
+		}
+		if err := annotation.CheckBase(base, claim); err != nil {
+			return "", fmt.Errorf("%s annotation volume %s claim of ordinal %d: %v", ClaimVolume, v.Name, pod.Ordinal, err)
+		}
+		return claim, nil
+	}
+	if v.ClaimName == "" {
+		return "", nil
+	}
+	return pod.ExpandName(v.ClaimName)
+}
+
+// setClaim replaces the source of the named volume, adding the volume when the pod has none
+func setClaim(spec *corev1.PodSpec, name string, claim *corev1.PersistentVolumeClaimVolumeSource) {
+	for i := range spec.Volumes {
+		if spec.Volumes[i].Name == name {
+			spec.Volumes[i].VolumeSource = corev1.VolumeSource{PersistentVolumeClaim: claim}
+			return
+		}
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name:         name,
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: claim},
+	})
+}
+
+func (h *ClaimHandler) Name() string {
+	return ClaimVolume
+}
+
+func (h *ClaimHandler) GetParser() annotation.Parser {
+	return claimParser
+}
+
+var _ annotation.Handler = &ClaimHandler{}
+var _ annotation.Named = &ClaimHandler{}
+var _ annotation.PodHandler = &ClaimHandler{}
+var _ annotation.Merging = &ClaimHandler{}
+
+func (h *ClaimHandler) MergesAnnotations() bool {
+	return true
+}
+
+var _ annotation.Dependent = &ClaimHandler{}
+
+// DependsOn mount-volume, so that a claim replaces the volume of the same name it mounted instead of duplicating it
+func (h *ClaimHandler) DependsOn() []string {
+	return []string{MountVolume}
+}
+
+// claimParser parses all the claim-volume annotations, ordered from the most specific qualifier
+var claimParser annotation.ParserFunc = func(annotations map[annotation.QualifiedName]string) (interface{}, error) {
+	var c claimConfig
+	for k, v := range annotations {
+		if k.Name != ClaimVolume {
+			continue
+		}
+		log.Info("parse config for claiming volumes", "qualifiedName", k, "value", v)
+		value := &claimConfigValue{}
+		if err := json.Unmarshal([]byte(v), value); err != nil {
+			return nil, err
+		}
+		for _, cv := range value.Volumes {
+			if cv.Name == "" {
+				return nil, fmt.Errorf("%s annotation has a volume without name", ClaimVolume)
+			}
+			for o := range cv.Claims {
+				if _, err := strconv.Atoi(o); err != nil {
+					return nil, fmt.Errorf("%s annotation volume %s has invalid ordinal %q", ClaimVolume, cv.Name, o)
+				}
+			}
+		}
+		c = append(c, claimConfigEntry{qualifier: k.Qualifier, cfg: value})
+	}
+	if c == nil {
+		return nil, nil
+	}
+	sort.Slice(c, func(i, j int) bool {
+		return annotation.LessQualifier(c[i].qualifier, c[j].qualifier)
+	})
+	return c, nil
+}
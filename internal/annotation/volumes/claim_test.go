@@ -0,0 +1,158 @@
+package volumes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestClaimHandler_MutatePod(t *testing.T) {
+	claim := func(name string) v1.VolumeSource {
+		return v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: name}}
+	}
+	newSpec := func() *v1.PodSpec {
+		return &v1.PodSpec{
+			Volumes: []v1.Volume{
+				{Name: "data", VolumeSource: claim("data-web-1")},
+				{Name: "config"},
+			},
+		}
+	}
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		ordinal     int
+		want        []v1.Volume
+		wantErr     bool
+	}{
+		{
+			name: "claim by ordinal",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ClaimVolume}: `{"volumes":[{"name":"data","claims":{"1":"restored-a"},"claimName":"restored"}]}`,
+			},
+			ordinal: 1,
+			want:    []v1.Volume{{Name: "data", VolumeSource: claim("restored-a")}, {Name: "config"}},
+		},
+		{
+			name: "claim by ordinal named after the statefulset",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ClaimVolume}: `{"volumes":[{"name":"data","claims":{"1":"web-restored-a"}}]}`,
+			},
+			ordinal: 1,
+			want:    []v1.Volume{{Name: "data", VolumeSource: claim("web-restored-a")}, {Name: "config"}},
+		},
+		{
+			name: "claim by ordinal without the base name",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ClaimVolume}: `{"volumes":[{"name":"data","claims":{"1":"restored-a"},"claimName":"{{.StatefulSet}}-restored-{{.Ordinal}}"}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+		{
+			name: "claim by ordinal sharing a prefix with the base name",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ClaimVolume}: `{"volumes":[{"name":"data","claims":{"1":"restoredb"},"claimName":"restored"}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+		{
+			name: "claim name suffixed with ordinal",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ClaimVolume}: `{"volumes":[{"name":"data","claims":{"0":"restored-a"},"claimName":"restored"}]}`,
+			},
+			ordinal: 1,
+			want:    []v1.Volume{{Name: "data", VolumeSource: claim("restored-1")}, {Name: "config"}},
+		},
+		{
+			name: "claim name template",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ClaimVolume}: `{"volumes":[{"name":"data","claimName":"{{.StatefulSet}}-restored-{{.Ordinal}}"}]}`,
+			},
+			ordinal: 1,
+			want:    []v1.Volume{{Name: "data", VolumeSource: claim("web-restored-1")}, {Name: "config"}},
+		},
+		{
+			name: "no claim for ordinal",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ClaimVolume}: `{"volumes":[{"name":"data","claims":{"0":"restored-a"}}]}`,
+			},
+			ordinal: 1,
+			want:    newSpec().Volumes,
+		},
+		{
+			name: "qualifier excludes ordinal",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ClaimVolume, Qualifier: "0"}: `{"volumes":[{"name":"data","claimName":"restored"}]}`,
+			},
+			ordinal: 1,
+			want:    newSpec().Volumes,
+		},
+		{
+			name: "volume added",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ClaimVolume, Qualifier: "1-"}: `{"volumes":[{"name":"backup","claimName":"backup"}]}`,
+			},
+			ordinal: 1,
+			want: []v1.Volume{
+				{Name: "data", VolumeSource: claim("data-web-1")},
+				{Name: "config"},
+				{Name: "backup", VolumeSource: claim("backup-1")},
+			},
+		},
+		{
+			name: "invalid template",
+			annotations: map[annotation.QualifiedName]string{
+				{Name: ClaimVolume}: `{"volumes":[{"name":"data","claimName":"{{.Unknown}}"}]}`,
+			},
+			ordinal: 1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &ClaimHandler{}
+			cfg, err := h.GetParser().Parse(tt.annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := annotation.NewPodInfo(tt.ordinal)
+			pod.StatefulSet = "web"
+			spec := newSpec()
+			if err := h.MutatePod(spec, pod, cfg); (err != nil) != tt.wantErr {
+				t.Fatalf("MutatePod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(spec.Volumes, tt.want) {
+				t.Errorf("MutatePod() volumes = %v, want %v", spec.Volumes, tt.want)
+			}
+		})
+	}
+}
+
+func Test_claimParser_Parse(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[annotation.QualifiedName]string
+		wantNil     bool
+		wantErr     bool
+	}{
+		{name: "no annotation", annotations: map[annotation.QualifiedName]string{{Name: MountVolume}: "{}"}, wantNil: true},
+		{name: "not json", annotations: map[annotation.QualifiedName]string{{Name: ClaimVolume}: "data"}, wantErr: true},
+		{name: "no volume name", annotations: map[annotation.QualifiedName]string{{Name: ClaimVolume}: `{"volumes":[{"claimName":"a"}]}`}, wantErr: true},
+		{name: "invalid ordinal", annotations: map[annotation.QualifiedName]string{{Name: ClaimVolume}: `{"volumes":[{"name":"data","claims":{"first":"a"}}]}`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := claimParser.Parse(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.wantNil || tt.wantErr) {
+				t.Errorf("Parse() got = %v", got)
+			}
+		})
+	}
+}
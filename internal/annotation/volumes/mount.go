@@ -2,7 +2,7 @@ package volumes
 
 import (
 	"fmt"
-	"strconv"
+	"sort"
 
 	"github.com/spoditor/spoditor/internal/annotation"
 	corev1 "k8s.io/api/core/v1"
@@ -16,7 +16,9 @@ const (
 
 var log = logf.Log.WithName("mount_volume")
 
-type mountConfig struct {
+type mountConfig []mountConfigEntry
+
+type mountConfigEntry struct {
 	qualifier string
 	cfg       *mountConfigValue
 }
@@ -30,67 +32,139 @@ type MountHandler struct {
 }
 
 func (h *MountHandler) Mutate(spec *corev1.PodSpec, ordinal int, cfg interface{}) error {
-	ll := log.WithValues("ordinal", ordinal)
-	m, ok := cfg.(*mountConfig)
+	return h.MutatePod(spec, annotation.NewPodInfo(ordinal), cfg)
+}
+
+func (h *MountHandler) MutatePod(spec *corev1.PodSpec, pod *annotation.PodInfo, cfg interface{}) error {
+	ll := log.WithValues("ordinal", pod.Ordinal)
+	c, ok := cfg.(mountConfig)
 	if !ok {
-		return fmt.Errorf("unexpected config type %T", m)
+		return fmt.Errorf("unexpected config type %T", cfg)
+	}
+	// the most specific qualifying annotation wins, as volumes can't be mounted twice
+	m := c.applied(pod.Ordinal)
+	if m == nil {
+		ll.Info("qualifier excludes this pod")
+		return nil
 	}
-	if should(ordinal, m.qualifier) {
-		ll.Info("pod should be applicable")
-		for _, v := range m.cfg.Volumes {
-			if v.ConfigMap != nil {
-				ll.Info("overwrite configmap name",
-					"volume", v.Name,
-					"origin", v.ConfigMap.LocalObjectReference.Name,
-					"new", v.ConfigMap.LocalObjectReference.Name+"-"+strconv.Itoa(ordinal))
-				v.ConfigMap.LocalObjectReference.Name += "-" + strconv.Itoa(ordinal)
+	ll.Info("pod should be applicable", "qualifier", m.qualifier)
+	for _, v := range m.cfg.Volumes {
+		if v.ConfigMap != nil {
+			n, err := pod.ExpandName(v.ConfigMap.LocalObjectReference.Name)
+			if err != nil {
+				return err
 			}
-			if v.Secret != nil {
-				ll.Info("overwrite secret name",
-					"volume", v.Name,
-					"origin", v.Secret.SecretName,
-					"new", v.Secret.SecretName+"-"+strconv.Itoa(ordinal))
-				v.Secret.SecretName += "-" + strconv.Itoa(ordinal)
+			ll.Info("overwrite configmap name",
+				"volume", v.Name,
+				"origin", v.ConfigMap.LocalObjectReference.Name,
+				"new", n)
+			v.ConfigMap.LocalObjectReference.Name = n
+		}
+		if v.Secret != nil {
+			n, err := pod.ExpandName(v.Secret.SecretName)
+			if err != nil {
+				return err
+			}
+			ll.Info("overwrite secret name",
+				"volume", v.Name,
+				"origin", v.Secret.SecretName,
+				"new", n)
+			v.Secret.SecretName = n
+		}
+		if v.Projected != nil {
+			if err := expandTokens(v.Projected, pod); err != nil {
+				return err
 			}
 		}
-		spec.Volumes = append(spec.Volumes, m.cfg.Volumes...)
-		for _, source := range m.cfg.Containers {
-			for i := 0; i < len(spec.Containers); i++ {
-				if source.Name == spec.Containers[i].Name {
-					ll.Info("mount volumes to container", "container", source.Name)
-					spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, source.VolumeMounts...)
-				}
+	}
+	spec.Volumes = append(spec.Volumes, m.cfg.Volumes...)
+	for _, source := range m.cfg.Containers {
+		for i := 0; i < len(spec.Containers); i++ {
+			if source.Name == spec.Containers[i].Name {
+				ll.Info("mount volumes to container", "container", source.Name)
+				spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, source.VolumeMounts...)
 			}
 		}
-	} else {
-		log.Info("qualifier excludes this pod")
 	}
 	return nil
 }
 
+// applied is the entry of the most specific annotation qualifying the ordinal, nil when none does
+func (c mountConfig) applied(ordinal int) *mountConfigEntry {
+	for i := range c {
+		if should(ordinal, c[i].qualifier) {
+			return &c[i]
+		}
+	}
+	return nil
+}
+
+// Qualifiers of the single annotation applied to the ordinal
+func (c mountConfig) Qualifiers(ordinal int) []string {
+	if m := c.applied(ordinal); m != nil {
+		return []string{m.qualifier}
+	}
+	return nil
+}
+
+var _ annotation.QualifiedConfig = mountConfig{}
+
+func (h *MountHandler) Name() string {
+	return MountVolume
+}
+
 func (h *MountHandler) GetParser() annotation.Parser {
 	return parser
 }
 
 var _ annotation.Handler = &MountHandler{}
+var _ annotation.Named = &MountHandler{}
+var _ annotation.PodHandler = &MountHandler{}
 
+// parser parses all the mount-volume annotations, ordered from the most specific qualifier
 var parser annotation.ParserFunc = func(annotations map[annotation.QualifiedName]string) (interface{}, error) {
+	var c mountConfig
 	for k, v := range annotations {
-		ll := log.WithValues("qualifiedName", k, "value", v)
-		if k.Name == MountVolume {
-			ll.Info("parse config for mounting volumes")
-			c := &mountConfigValue{}
-			if err := json.Unmarshal([]byte(v), c); err == nil {
-				return &mountConfig{
-					qualifier: k.Qualifier,
-					cfg:       c,
-				}, nil
-			} else {
-				return nil, err
+		if k.Name != MountVolume {
+			continue
+		}
+		log.Info("parse config for mounting volumes", "qualifiedName", k, "value", v)
+		value := &mountConfigValue{}
+		if err := json.Unmarshal([]byte(v), value); err != nil {
+			return nil, err
+		}
+		c = append(c, mountConfigEntry{qualifier: k.Qualifier, cfg: value})
+	}
+	if c == nil {
+		return nil, nil
+	}
+	sort.Slice(c, func(i, j int) bool {
+		return annotation.LessQualifier(c[i].qualifier, c[j].qualifier)
+	})
+	return c, nil
+}
+
+// expandTokens expands the audience and path templates of the serviceAccountToken sources of a projected volume,
+// e.g. for each member of a StatefulSet to get a token of its own audience
+func expandTokens(p *corev1.ProjectedVolumeSource, pod *annotation.PodInfo) error {
+	var err error
+	for _, s := range p.Sources {
+		t := s.ServiceAccountToken
+		if t == nil {
+			continue
+		}
+		if annotation.IsTemplate(t.Audience) {
+			if t.Audience, err = pod.ExpandText(t.Audience); err != nil {
+				return err
+			}
+		}
+		if annotation.IsTemplate(t.Path) {
+			if t.Path, err = pod.ExpandText(t.Path); err != nil {
+				return err
 			}
 		}
 	}
-	return nil, nil
+	return nil
 }
 
 var should = annotation.CommonPodQualifier
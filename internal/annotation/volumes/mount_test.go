@@ -37,9 +37,8 @@ func TestMountHandler_Mutate(t *testing.T) {
 			args: args{
 				spec:    &v1.PodSpec{},
 				ordinal: 0,
-				cfg: &mountConfig{
-					qualifier: "1-2",
-					cfg:       nil,
+				cfg: mountConfig{
+					{qualifier: "1-2", cfg: nil},
 				},
 			},
 			want:    &v1.PodSpec{},
@@ -56,7 +55,7 @@ func TestMountHandler_Mutate(t *testing.T) {
 					},
 				},
 				ordinal: 0,
-				cfg: &mountConfig{
+				cfg: mountConfig{{
 					qualifier: "",
 					cfg: &mountConfigValue{
 						Volumes: []v1.Volume{
@@ -83,7 +82,7 @@ func TestMountHandler_Mutate(t *testing.T) {
 							},
 						},
 					},
-				},
+				}},
 			},
 			want: &v1.PodSpec{
 				Containers: []v1.Container{
@@ -123,7 +122,7 @@ func TestMountHandler_Mutate(t *testing.T) {
 					},
 				},
 				ordinal: 0,
-				cfg: &mountConfig{
+				cfg: mountConfig{{
 					qualifier: "",
 					cfg: &mountConfigValue{
 						Volumes: []v1.Volume{
@@ -148,7 +147,7 @@ func TestMountHandler_Mutate(t *testing.T) {
 							},
 						},
 					},
-				},
+				}},
 			},
 			want: &v1.PodSpec{
 				Containers: []v1.Container{
@@ -188,11 +187,103 @@ func TestMountHandler_Mutate(t *testing.T) {
 	}
 }
 
+func TestMountHandler_MutatePod_ProjectedToken(t *testing.T) {
+	token := func(audience, path string) v1.VolumeProjection {
+		return v1.VolumeProjection{ServiceAccountToken: &v1.ServiceAccountTokenProjection{Audience: audience, Path: path}}
+	}
+	tests := []struct {
+		name    string
+		value   string
+		want    []v1.VolumeProjection
+		wantErr bool
+	}{
+		{
+			name: "audience and path templates",
+			value: `{"volumes":[{"name":"token","projected":{"sources":[
+				{"serviceAccountToken":{"audience":"https://auth.example.com/{{.StatefulSet}}/{{.Ordinal}}","path":"{{.StatefulSet}}/token-{{.Ordinal}}"}},
+				{"serviceAccountToken":{"audience":"vault","path":"vault-token"}}
+			]}}]}`,
+			want: []v1.VolumeProjection{
+				token("https://auth.example.com/kafka/2", "kafka/token-2"),
+				token("vault", "vault-token"),
+			},
+		},
+		{
+			name:    "invalid template",
+			value:   `{"volumes":[{"name":"token","projected":{"sources":[{"serviceAccountToken":{"audience":"{{.Broker}}","path":"token"}}]}}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MountHandler{}
+			cfg, err := h.GetParser().Parse(map[annotation.QualifiedName]string{{Name: MountVolume}: tt.value})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod := annotation.NewPodInfo(2)
+			pod.StatefulSet = "kafka"
+			spec := &v1.PodSpec{}
+			if err := h.MutatePod(spec, pod, cfg); (err != nil) != tt.wantErr {
+				t.Fatalf("MutatePod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(spec.Volumes[0].Projected.Sources, tt.want) {
+				t.Errorf("MutatePod() sources = %+v, want %+v", spec.Volumes[0].Projected.Sources, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountHandler_MutatePod_OverlappingQualifiers(t *testing.T) {
+	volume := func(name string) string {
+		return fmt.Sprintf(`{"volumes":[{"name":"data","configMap":{"name":"%s"}}],`+
+			`"containers":[{"name":"main","volumeMounts":[{"name":"data","mountPath":"/data"}]}]}`, name)
+	}
+	annotations := map[annotation.QualifiedName]string{
+		{Name: MountVolume}:                   volume("all"),
+		{Name: MountVolume, Qualifier: "0"}:   volume("first"),
+		{Name: MountVolume, Qualifier: "1"}:   volume("second"),
+		{Name: MountVolume, Qualifier: "1-2"}: volume("range"),
+	}
+	tests := []struct {
+		name          string
+		ordinal       int
+		wantConfigMap string
+	}{
+		{name: "single ordinal over the others", ordinal: 1, wantConfigMap: "second-1"},
+		{name: "single ordinal over no qualifier", ordinal: 0, wantConfigMap: "first-0"},
+		{name: "range over no qualifier", ordinal: 2, wantConfigMap: "range-2"},
+		{name: "no qualifier", ordinal: 3, wantConfigMap: "all-3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MountHandler{}
+			cfg, err := h.GetParser().Parse(annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec := &v1.PodSpec{Containers: []v1.Container{{Name: "main"}}}
+			if err := h.Mutate(spec, tt.ordinal, cfg); err != nil {
+				t.Fatal(err)
+			}
+			if len(spec.Volumes) != 1 || spec.Volumes[0].ConfigMap.Name != tt.wantConfigMap {
+				t.Errorf("Mutate() volumes = %+v, want the single configmap %s", spec.Volumes, tt.wantConfigMap)
+			}
+			if len(spec.Containers[0].VolumeMounts) != 1 {
+				t.Errorf("Mutate() mounts = %+v, want a single mount", spec.Containers[0].VolumeMounts)
+			}
+			if q := cfg.(annotation.QualifiedConfig).Qualifiers(tt.ordinal); len(q) != 1 {
+				t.Errorf("Qualifiers() = %v, want the single applied qualifier", q)
+			}
+		})
+	}
+}
+
 func Test_parserFunc_Parse(t *testing.T) {
 	type args struct {
 		annotations map[annotation.QualifiedName]string
 	}
-	c := &mountConfig{
+	c := mountConfigEntry{
 		qualifier: "1-2",
 		cfg: &mountConfigValue{
 			Volumes: []v1.Volume{
@@ -247,7 +338,7 @@ func Test_parserFunc_Parse(t *testing.T) {
 					return string(b)
 				}(),
 			}},
-			want:    c,
+			want:    mountConfig{c},
 			wantErr: false,
 		},
 		{
@@ -259,7 +350,7 @@ func Test_parserFunc_Parse(t *testing.T) {
 					Name:      MountVolume,
 				}: "{\"volumes\":[{\"name\": \"my-volume\", \"configMap\":{\"name\":\"my-configmap\"}}],\"containers\":[{\"name\":\"nginx\", \"volumeMounts\":[{\"name\":\"my-volume\",\"mountPath\":\"/etc/configmaps/my-volume\"}]}]}",
 			}},
-			want: &mountConfig{
+			want: mountConfig{{
 				qualifier: "1-2",
 				cfg: &mountConfigValue{
 					Volumes: []v1.Volume{
@@ -286,9 +377,36 @@ func Test_parserFunc_Parse(t *testing.T) {
 						},
 					},
 				},
+			}},
+			wantErr: false,
+		},
+		{
+			name: "ordered from the most specific qualifier",
+			p:    parser,
+			args: args{annotations: map[annotation.QualifiedName]string{
+				{Name: MountVolume}:                   `{}`,
+				{Name: MountVolume, Qualifier: "0"}:   `{}`,
+				{Name: MountVolume, Qualifier: "1"}:   `{}`,
+				{Name: MountVolume, Qualifier: "0-2"}: `{}`,
+			}},
+			want: mountConfig{
+				{qualifier: "0", cfg: &mountConfigValue{}},
+				{qualifier: "1", cfg: &mountConfigValue{}},
+				{qualifier: "0-2", cfg: &mountConfigValue{}},
+				{qualifier: "", cfg: &mountConfigValue{}},
 			},
 			wantErr: false,
 		},
+		{
+			name: "invalid json",
+			p:    parser,
+			args: args{annotations: map[annotation.QualifiedName]string{
+				{Name: MountVolume}:                 `{}`,
+				{Name: MountVolume, Qualifier: "1"}: `not json`,
+			}},
+			want:    nil,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	"k8s.io/apimachinery/pkg/util/json"
+)
+
+const (
+	// Applied is the name of the pod annotation summarizing the mutations spoditor applied
+	Applied = "applied"
+)
+
+// Version of spoditor, overridden at build time with -ldflags "-X github.com/spoditor/spoditor/internal.Version=..."
+var Version = "dev"
+
+// AppliedSummary is recorded as JSON in the spoditor.io/applied annotation of a mutated pod
+type AppliedSummary struct {
+	Version  string           `json:"version"`
+	Ordinal  int              `json:"ordinal"`
+	Handlers []AppliedHandler `json:"handlers"`
+}
+
+// AppliedHandler describes the mutation of a single handler
+type AppliedHandler struct {
+	Name string `json:"name"`
+	// Qualifiers of the handler's annotations matching the pod ordinal, empty string for dynamic argumentation
+	Qualifiers []string `json:"qualifiers"`
+	// ConfigHash is the sha256 of the matching annotation values, ordered by qualifier
+	ConfigHash string `json:"configHash"`
+}
+
+// newAppliedHandler summarizes the annotations claimed by the named handler which qualify the ordinal, only the ones
+// applied to the ordinal when the configuration is an annotation.QualifiedConfig
+func newAppliedHandler(name string, ordinal int, annotations map[annotation.QualifiedName]string, cfg interface{}) AppliedHandler {
+	a := AppliedHandler{Name: name, Qualifiers: []string{}}
+	var parsed map[string]bool
+	if q, ok := cfg.(annotation.QualifiedConfig); ok {
+		parsed = map[string]bool{}
+		for _, qualifier := range q.Qualifiers(ordinal) {
+			parsed[qualifier] = true
+		}
+	}
+	for k := range annotations {
+		if k.Name == name && annotation.CommonPodQualifier(ordinal, k.Qualifier) && (parsed == nil || parsed[k.Qualifier]) {
+			a.Qualifiers = append(a.Qualifiers, k.Qualifier)
+		}
+	}
+	sort.Strings(a.Qualifiers)
+	h := sha256.New()
+	for _, q := range a.Qualifiers {
+		fmt.Fprintf(h, "%s=%s\n", q, annotations[annotation.QualifiedName{Qualifier: q, Name: name}])
+	}
+	a.ConfigHash = hex.EncodeToString(h.Sum(nil))
+	return a
+}
+
+// newAppliedPolicy summarizes the rules of a policy of the kind applied to a pod
+func newAppliedPolicy(kind, name string, spec interface{}, ordinals []string) AppliedHandler {
+	a := AppliedHandler{Name: kind + "/" + name, Qualifiers: ordinals}
+	h := sha256.New()
+	if b, err := json.Marshal(spec); err == nil {
+		h.Write(b)
+	}
+	a.ConfigHash = hex.EncodeToString(h.Sum(nil))
+	return a
+}
+
+func (s *AppliedSummary) String() string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Sprintf("%v", err)
+	}
+	return string(b)
+}
+
+// handlerName identifies a handler by its annotation name when it declares one, by its type otherwise
+func handlerName(h annotation.Handler) string {
+	if n, ok := h.(annotation.Named); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", h)
+}
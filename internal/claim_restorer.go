@@ -0,0 +1,425 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// RestoreClaims configures the claims the ClaimRestorer creates from snapshots or clones for the qualified ordinals
+	RestoreClaims = "restore-claims"
+)
+
+// SnapshotGroup is the API group of VolumeSnapshots
+const SnapshotGroup = "snapshot.storage.k8s.io"
+
+// snapshotVersions are tried in order to check that a VolumeSnapshot exists
+var snapshotVersions = []string{"v1", "v1beta1"}
+
+// restoreWait is how long the restorer waits for a missing data source
+const restoreWait = 30 * time.Second
+
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create
+// +kubebuilder:webhook:path=/mutate-v1-persistentvolumeclaim,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=persistentvolumeclaims,verbs=create,versions=v1,name=mpvc.spoditor.io,admissionReviewVersions={v1,v1beta1}
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get
+
+// RestoreClaimsConfig is the value of the spoditor.io/restore-claims annotation
+type RestoreClaimsConfig struct {
+	Claims []RestoreClaim `json:"claims"`
+}
+
+// RestoreClaim populates the claim of a volumeClaimTemplate from either a snapshot or a clone source
+type RestoreClaim struct {
+	// Name of the volumeClaimTemplate
+	Name string `json:"name"`
+	// Snapshot is the VolumeSnapshot, suffixed with -<ordinal> unless it is a name template
+	Snapshot string `json:"snapshot,omitempty"`
+	// CloneFrom is the PersistentVolumeClaim, suffixed with -<ordinal> unless it is a name template
+	CloneFrom string `json:"cloneFrom,omitempty"`
+}
+
+// ParseRestoreClaims parses and validates the value of a spoditor.io/restore-claims annotation
+func ParseRestoreClaims(v string) (*RestoreClaimsConfig, error) {
+	c := &RestoreClaimsConfig{}
+	if err := json.Unmarshal([]byte(v), c); err != nil {
+		return nil, err
+	}
+	for _, claim := range c.Claims {
+		if claim.Name == "" {
+			return nil, fmt.Errorf("%s annotation has a claim without name", RestoreClaims)
+		}
+		if (claim.Snapshot == "") == (claim.CloneFrom == "") {
+			return nil, fmt.Errorf("%s annotation claim %s needs either a snapshot or a cloneFrom", RestoreClaims, claim.Name)
+		}
+	}
+	return c, nil
+}
+
+// ClaimRestorer creates the PersistentVolumeClaims of the volumeClaimTemplates of a StatefulSet, named
+// <template>-<statefulset>-<ordinal>, from the snapshots or clone sources of the spoditor.io/restore-claims
+// annotations of its pod template. It ensures the claims of the existing ordinals and of the next one, and as a
+// webhook it sets the data source of the claims the StatefulSet controller creates first, denying them while
+// the source doesn't exist so that the pod waits for its restored claim
+type ClaimRestorer struct {
+	client.Client
+	decoder   *admission.Decoder
+	Collector annotation.QualifiedAnnotationCollector
+	// QualifierOrdinals is the default ordinal qualifiers are evaluated against
+	QualifierOrdinals OrdinalMode
+	// OrdinalsStart is optional, without it the ordinals start at 0
+	OrdinalsStart OrdinalsStartResolver
+	// Recorder is optional, when set claims restored or waiting for their source are recorded as events
+	Recorder record.EventRecorder
+}
+
+func (r *ClaimRestorer) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ll := log.WithValues("statefulset", req.NamespacedName)
+	ss := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, req.NamespacedName, ss); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	annotations := r.Collector.Collect(&ss.Spec.Template)
+	// the most specific annotation restores the claim, the others skipping the existing claim
+	qualifiers := restoreQualifiers(annotations)
+	if len(qualifiers) == 0 {
+		return ctrl.Result{}, nil
+	}
+	mode, start, err := r.ordinals(ctx, ss, annotations)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	replicas := 1
+	if ss.Spec.Replicas != nil {
+		replicas = int(*ss.Spec.Replicas)
+	}
+
+	var result ctrl.Result
+	for ordinal := start; ordinal <= start+replicas; ordinal++ {
+		pod := restoredPodInfo(ss, mode, start, ordinal)
+		for _, q := range qualifiers {
+			if !annotation.CommonPodQualifier(pod.Ordinal, q) {
+				continue
+			}
+			c, err := ParseRestoreClaims(annotations[annotation.QualifiedName{Name: RestoreClaims, Qualifier: q}])
+			if err != nil {
+				ll.Error(err, "invalid annotation", "qualifier", q)
+				r.event(ss, v1.EventTypeWarning, "InvalidRestoreClaims", err.Error())
+				return ctrl.Result{}, nil
+			}
+			for _, claim := range c.Claims {
+				waiting, err := r.restore(ctx, ss, pod, claim)
+				if err != nil {
+					return ctrl.Result{}, err
+				}
+				if waiting {
+					result.RequeueAfter = restoreWait
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// restore creates the claim of the pod unless it exists, returning true when its data source doesn't exist yet
+func (r *ClaimRestorer) restore(ctx context.Context, ss *appsv1.StatefulSet, pod *annotation.PodInfo, claim RestoreClaim) (bool, error) {
+	name := fmt.Sprintf("%s-%s-%d", claim.Name, ss.Name, pod.AbsoluteOrdinal)
+	ll := log.WithValues("statefulset", ss.Name, "namespace", ss.Namespace, "claim", name)
+	err := r.Get(ctx, client.ObjectKey{Namespace: ss.Namespace, Name: name}, &v1.PersistentVolumeClaim{})
+	if err == nil {
+		return false, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	var template *v1.PersistentVolumeClaim
+	for i := range ss.Spec.VolumeClaimTemplates {
+		if ss.Spec.VolumeClaimTemplates[i].Name == claim.Name {
+			template = &ss.Spec.VolumeClaimTemplates[i]
+		}
+	}
+	if template == nil {
+		ll.Info("no volumeClaimTemplate for the claim")
+		r.event(ss, v1.EventTypeWarning, "InvalidRestoreClaims", fmt.Sprintf("no volumeClaimTemplate %s to restore", claim.Name))
+		return false, nil
+	}
+
+	source, exists, err := r.source(ctx, ss.Namespace, pod, claim)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		ll.Info("data source not found", "kind", source.Kind, "name", source.Name)
+		r.event(ss, v1.EventTypeWarning, "RestoreSourceNotFound",
+			fmt.Sprintf("%s %s of claim %s not found", source.Kind, source.Name, name))
+		return true, nil
+	}
+
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   ss.Namespace,
+			Name:        name,
+			Labels:      map[string]string{},
+			Annotations: template.Annotations,
+		},
+		Spec: *template.Spec.DeepCopy(),
+	}
+	for k, v := range template.Labels {
+		pvc.Labels[k] = v
+	}
+	if ss.Spec.Selector != nil {
+		// as the StatefulSet controller labels the claims it creates
+		for k, v := range ss.Spec.Selector.MatchLabels {
+			pvc.Labels[k] = v
+		}
+	}
+	pvc.Spec.DataSource = source
+	if err := r.Create(ctx, pvc); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	ll.Info("restored claim", "kind", source.Kind, "source", source.Name)
+	r.event(ss, v1.EventTypeNormal, "RestoredClaim", fmt.Sprintf("created claim %s from %s %s", name, source.Kind, source.Name))
+	return false, nil
+}
+
+// restoreQualifiers are the qualifiers of the restore-claims annotations, the most specific first
+func restoreQualifiers(annotations map[annotation.QualifiedName]string) []string {
+	var qualifiers []string
+	for k := range annotations {
+		if k.Name == RestoreClaims {
+			qualifiers = append(qualifiers, k.Qualifier)
+		}
+	}
+	sort.Slice(qualifiers, func(i, j int) bool {
+		return annotation.LessQualifier(qualifiers[i], qualifiers[j])
+	})
+	return qualifiers
+}
+
+// ordinals resolves the ordinal mode and the first ordinal of the StatefulSet
+func (r *ClaimRestorer) ordinals(ctx context.Context, ss *appsv1.StatefulSet, annotations map[annotation.QualifiedName]string) (OrdinalMode, int, error) {
+	mode, err := resolveOrdinalMode(r.QualifierOrdinals, annotations)
+	if err != nil {
+		return "", 0, err
+	}
+	start := 0
+	if r.OrdinalsStart != nil {
+		if start, err = r.OrdinalsStart.OrdinalsStart(ctx, ss.Namespace, ss.Name); err != nil {
+			return "", 0, err
+		}
+	}
+	return mode, start, nil
+}
+
+// restoredPodInfo is the info of the pod of the absolute ordinal, which name templates are expanded with
+func restoredPodInfo(ss *appsv1.StatefulSet, mode OrdinalMode, start, ordinal int) *annotation.PodInfo {
+	pod := annotation.NewPodInfo(ordinal)
+	pod.Name = fmt.Sprintf("%s-%d", ss.Name, ordinal)
+	pod.Namespace = ss.Namespace
+	pod.StatefulSet = ss.Name
+	pod.LogicalOrdinal = ordinal - start
+	if mode == OrdinalModeLogical {
+		pod.Ordinal = pod.LogicalOrdinal
+	}
+	return pod
+}
+
+// source is the data source of the claim of the pod, and whether it exists
+func (r *ClaimRestorer) source(ctx context.Context, namespace string, pod *annotation.PodInfo, claim RestoreClaim) (*v1.TypedLocalObjectReference, bool, error) {
+	source := &v1.TypedLocalObjectReference{}
+	var err error
+	if claim.Snapshot != "" {
+		if source.Name, err = pod.ExpandName(claim.Snapshot); err != nil {
+			return nil, false, err
+		}
+		group := SnapshotGroup
+		source.APIGroup, source.Kind = &group, "VolumeSnapshot"
+		exists, err := r.snapshotExists(ctx, namespace, source.Name)
+		return source, exists, err
+	}
+	if source.Name, err = pod.ExpandName(claim.CloneFrom); err != nil {
+		return nil, false, err
+	}
+	source.Kind = "PersistentVolumeClaim"
+	err = r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: source.Name}, &v1.PersistentVolumeClaim{})
+	if apierrors.IsNotFound(err) {
+		return source, false, nil
+	}
+	return source, err == nil, err
+}
+
+func (r *ClaimRestorer) snapshotExists(ctx context.Context, namespace, name string) (bool, error) {
+	for _, version := range snapshotVersions {
+		s := &unstructured.Unstructured{}
+		s.SetGroupVersionKind(schema.GroupVersionKind{Group: SnapshotGroup, Version: version, Kind: "VolumeSnapshot"})
+		err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, s)
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, fmt.Errorf("no VolumeSnapshot API in group %s", SnapshotGroup)
+}
+
+func (r *ClaimRestorer) event(ss *appsv1.StatefulSet, eventType, reason, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(ss, eventType, reason, message)
+	}
+}
+
+// Handle sets the data source of a claim of a volumeClaimTemplate created without one
+func (r *ClaimRestorer) Handle(ctx context.Context, request admission.Request) admission.Response {
+	pvc := &v1.PersistentVolumeClaim{}
+	if err := r.decoder.Decode(request, pvc); err != nil {
+		return admission.Allowed(fmt.Sprintf("failed to decode the input claim %v", err))
+	}
+	if pvc.Spec.DataSource != nil {
+		return admission.Allowed("claim has a data source")
+	}
+	namespace := pvc.Namespace
+	if namespace == "" {
+		namespace = request.Namespace
+	}
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, statefulSets, client.InNamespace(namespace)); err != nil {
+		return admission.Errored(http.StatusInternalServerError, fmt.Errorf("failed to list statefulsets %v", err))
+	}
+	for i := range statefulSets.Items {
+		ss := &statefulSets.Items[i]
+		if template, ordinal, ok := claimOrdinal(ss, pvc.Name); ok {
+			return r.handleClaim(ctx, request, pvc, ss, template, ordinal)
+		}
+	}
+	return admission.Allowed("claim of no volumeClaimTemplate")
+}
+
+// claimOrdinal matches the name of a claim with <template>-<statefulset>-<ordinal>
+func claimOrdinal(ss *appsv1.StatefulSet, name string) (string, int, bool) {
+	for _, t := range ss.Spec.VolumeClaimTemplates {
+		prefix := fmt.Sprintf("%s-%s-", t.Name, ss.Name)
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		suffix := strings.TrimPrefix(name, prefix)
+		if ordinal, err := strconv.Atoi(suffix); err == nil && ordinal >= 0 && strconv.Itoa(ordinal) == suffix {
+			return t.Name, ordinal, true
+		}
+	}
+	return "", 0, false
+}
+
+func (r *ClaimRestorer) handleClaim(ctx context.Context, request admission.Request, pvc *v1.PersistentVolumeClaim, ss *appsv1.StatefulSet, template string, ordinal int) admission.Response {
+	ll := log.WithValues("statefulset", ss.Name, "namespace", ss.Namespace, "claim", pvc.Name)
+	// the webhook has no side effects, the claims of dry-run requests aren't created
+	event := func(eventType, reason, message string) {
+		if !dryRunRequest(request) {
+			r.event(ss, eventType, reason, message)
+		}
+	}
+	annotations := r.Collector.Collect(&ss.Spec.Template)
+	qualifiers := restoreQualifiers(annotations)
+	if len(qualifiers) == 0 {
+		return admission.Allowed("no claim to restore")
+	}
+	mode, start, err := r.ordinals(ctx, ss, annotations)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	pod := restoredPodInfo(ss, mode, start, ordinal)
+	for _, q := range qualifiers {
+		if !annotation.CommonPodQualifier(pod.Ordinal, q) {
+			continue
+		}
+		c, err := ParseRestoreClaims(annotations[annotation.QualifiedName{Name: RestoreClaims, Qualifier: q}])
+		if err != nil {
+			ll.Error(err, "invalid annotation", "qualifier", q)
+			event(v1.EventTypeWarning, "InvalidRestoreClaims", err.Error())
+			return admission.Allowed(fmt.Sprintf("invalid %s annotation %v", RestoreClaims, err))
+		}
+		for _, claim := range c.Claims {
+			if claim.Name != template {
+				continue
+			}
+			source, exists, err := r.source(ctx, ss.Namespace, pod, claim)
+			if err != nil {
+				return admission.Errored(http.StatusInternalServerError, err)
+			}
+			if !exists {
+				// the StatefulSet controller retries the claim, and creates the pod once it exists
+				message := fmt.Sprintf("%s %s of claim %s not found", source.Kind, source.Name, pvc.Name)
+				ll.Info("data source not found", "kind", source.Kind, "name", source.Name)
+				event(v1.EventTypeWarning, "RestoreSourceNotFound", message)
+				return admission.Denied(message)
+			}
+			pvc.Spec.DataSource = source
+			marshaled, err := json.Marshal(pvc)
+			if err != nil {
+				return admission.Errored(http.StatusInternalServerError, err)
+			}
+			ll.Info("restored claim", "kind", source.Kind, "source", source.Name)
+			event(v1.EventTypeNormal, "RestoredClaim", fmt.Sprintf("set the data source of claim %s to %s %s", pvc.Name, source.Kind, source.Name))
+			return admission.PatchResponseFromRaw(request.Object.Raw, marshaled)
+		}
+	}
+	return admission.Allowed("no claim to restore")
+}
+
+func (r *ClaimRestorer) InjectDecoder(decoder *admission.Decoder) error {
+	r.decoder = decoder
+	return nil
+}
+
+func (r *ClaimRestorer) SetupWebhookWithManager(mgr ctrl.Manager) {
+	log.Info("registering claim restorer webhook")
+	setupClaimWebhookWithManager(mgr, r)
+}
+
+// allowClaims admits the claims unchanged when they aren't restored
+var allowClaims admission.HandlerFunc = func(context.Context, admission.Request) admission.Response {
+	return admission.Allowed("claims aren't restored")
+}
+
+// SetupAllowingClaimWebhookWithManager admits every claim without the claim restorer, the webhook configuration of
+// the manifests sending the claims regardless
+func SetupAllowingClaimWebhookWithManager(mgr ctrl.Manager) {
+	log.Info("registering allowing claim webhook")
+	setupClaimWebhookWithManager(mgr, allowClaims)
+}
+
+func setupClaimWebhookWithManager(mgr ctrl.Manager, handler admission.Handler) {
+	mgr.GetWebhookServer().
+		Register("/mutate-v1-persistentvolumeclaim", &webhook.Admission{
+			Handler: handler,
+		})
+}
+
+func (r *ClaimRestorer) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&appsv1.StatefulSet{}).
+		Complete(r)
+}
@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spoditor/spoditor/internal/annotation"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func restoringStatefulSet(namespace string, replicas int32, annotations map[string]string) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "web"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}, Annotations: annotations},
+				Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "web", Image: "nginx"}}},
+			},
+			VolumeClaimTemplates: []v1.PersistentVolumeClaim{{
+				ObjectMeta: metav1.ObjectMeta{Name: "data"},
+				Spec: v1.PersistentVolumeClaimSpec{
+					AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+					},
+				},
+			}},
+		},
+	}
+}
+
+func volumeSnapshot(namespace, name string) *unstructured.Unstructured {
+	s := &unstructured.Unstructured{}
+	s.SetAPIVersion(SnapshotGroup + "/v1")
+	s.SetKind("VolumeSnapshot")
+	s.SetNamespace(namespace)
+	s.SetName(name)
+	return s
+}
+
+func claim(name string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+}
+
+func TestClaimRestorer_Reconcile(t *testing.T) {
+	snapshot := map[string]string{"spoditor.io/restore-claims": `{"claims":[{"name":"data","snapshot":"data-backup"}]}`}
+	tests := []struct {
+		name        string
+		ss          *appsv1.StatefulSet
+		objects     []client.Object
+		want        map[string]string
+		wantRequeue bool
+	}{
+		{
+			name:    "restore from snapshots, including the next ordinal",
+			ss:      restoringStatefulSet("default", 1, snapshot),
+			objects: []client.Object{volumeSnapshot("default", "data-backup-0"), volumeSnapshot("default", "data-backup-1")},
+			want:    map[string]string{"data-web-0": "VolumeSnapshot/data-backup-0", "data-web-1": "VolumeSnapshot/data-backup-1"},
+		},
+		{
+			name:        "wait for a missing snapshot",
+			ss:          restoringStatefulSet("default", 1, snapshot),
+			objects:     []client.Object{volumeSnapshot("default", "data-backup-0")},
+			want:        map[string]string{"data-web-0": "VolumeSnapshot/data-backup-0"},
+			wantRequeue: true,
+		},
+		{
+			name:    "existing claim is kept",
+			ss:      restoringStatefulSet("default", 0, snapshot),
+			objects: []client.Object{volumeSnapshot("default", "data-backup-0"), claim("data-web-0")},
+			want:    map[string]string{"data-web-0": ""},
+		},
+		{
+			name: "clone from a name template for the qualified ordinals",
+			ss: restoringStatefulSet("default", 2, map[string]string{
+				"spoditor.io/restore-claims_1-": `{"claims":[{"name":"data","cloneFrom":"old-{{.LogicalOrdinal}}"}]}`,
+			}),
+			objects: []client.Object{claim("old-1"), claim("old-2")},
+			want: map[string]string{
+				"old-1": "", "old-2": "",
+				"data-web-1": "PersistentVolumeClaim/old-1", "data-web-2": "PersistentVolumeClaim/old-2",
+			},
+		},
+		{
+			name:    "no annotation",
+			ss:      restoringStatefulSet("default", 1, nil),
+			objects: []client.Object{volumeSnapshot("default", "data-backup-0")},
+			want:    map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(append(tt.objects, tt.ss)...).Build()
+			r := &ClaimRestorer{Client: c, Collector: annotation.Collector}
+			result, err := r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}})
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if got := result.RequeueAfter > 0; got != tt.wantRequeue {
+				t.Errorf("Reconcile() requeue = %v, want %v", got, tt.wantRequeue)
+			}
+			claims := &v1.PersistentVolumeClaimList{}
+			if err := c.List(context.TODO(), claims); err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, pvc := range claims.Items {
+				got[pvc.Name] = ""
+				if s := pvc.Spec.DataSource; s != nil {
+					got[pvc.Name] = s.Kind + "/" + s.Name
+					if pvc.Labels["app"] != "web" || pvc.Spec.Resources.Requests.Storage().String() != "1Gi" {
+						t.Errorf("claim %s = %+v, want the labels and spec of the template", pvc.Name, pvc)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("claims = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimRestorer_Handle(t *testing.T) {
+	snapshot := map[string]string{"spoditor.io/restore-claims": `{"claims":[{"name":"data","snapshot":"data-backup"}]}`}
+	tests := []struct {
+		name        string
+		claim       string
+		ss          *appsv1.StatefulSet
+		objects     []client.Object
+		dataSource  *v1.TypedLocalObjectReference
+		dryRun      bool
+		wantDenied  bool
+		wantPatched bool
+		wantEvent   bool
+	}{
+		{
+			name:        "claim of a new ordinal restored from its snapshot",
+			claim:       "data-web-3",
+			ss:          restoringStatefulSet("default", 4, snapshot),
+			objects:     []client.Object{volumeSnapshot("default", "data-backup-3")},
+			wantPatched: true,
+			wantEvent:   true,
+		},
+		{
+			name:        "no event for a dry-run claim",
+			claim:       "data-web-3",
+			ss:          restoringStatefulSet("default", 4, snapshot),
+			objects:     []client.Object{volumeSnapshot("default", "data-backup-3")},
+			dryRun:      true,
+			wantPatched: true,
+		},
+		{
+			name:       "claim denied until its snapshot exists",
+			claim:      "data-web-3",
+			ss:         restoringStatefulSet("default", 4, snapshot),
+			wantDenied: true,
+			wantEvent:  true,
+		},
+		{
+			name:    "claim with a data source",
+			claim:   "data-web-3",
+			ss:      restoringStatefulSet("default", 4, snapshot),
+			objects: []client.Object{volumeSnapshot("default", "data-backup-3")},
+			dataSource: &v1.TypedLocalObjectReference{
+				Kind: "PersistentVolumeClaim", Name: "other",
+			},
+		},
+		{
+			name:  "claim of no volumeClaimTemplate",
+			claim: "data-web-other",
+			ss:    restoringStatefulSet("default", 4, snapshot),
+		},
+		{
+			name:  "ordinal not qualified",
+			claim: "data-web-0",
+			ss: restoringStatefulSet("default", 4, map[string]string{
+				"spoditor.io/restore-claims_1-": `{"claims":[{"name":"data","snapshot":"data-backup"}]}`,
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(append(tt.objects, tt.ss)...).Build()
+			d, err := admission.NewDecoder(scheme.Scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := record.NewFakeRecorder(1)
+			r := &ClaimRestorer{Client: c, Collector: annotation.Collector, Recorder: recorder}
+			if err := r.InjectDecoder(d); err != nil {
+				t.Fatal(err)
+			}
+			pvc := claim(tt.claim)
+			pvc.Spec.DataSource = tt.dataSource
+			raw, err := json.Marshal(pvc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := r.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Namespace: "default",
+				Object:    runtime.RawExtension{Raw: raw},
+				DryRun:    &tt.dryRun,
+			}})
+			if resp.Allowed == tt.wantDenied {
+				t.Fatalf("Handle() allowed = %v, want denied %v: %v", resp.Allowed, tt.wantDenied, resp.Result)
+			}
+			if got := len(resp.Patches) > 0; got != tt.wantPatched {
+				t.Fatalf("Handle() patches = %v, want patched %v", resp.Patches, tt.wantPatched)
+			}
+			if tt.wantPatched {
+				patch := resp.Patches[0]
+				source, ok := patch.Value.(map[string]interface{})
+				if patch.Path != "/spec/dataSource" || !ok || source["kind"] != "VolumeSnapshot" || source["name"] != "data-backup-3" {
+					t.Errorf("Handle() patch = %+v, want the data source of snapshot data-backup-3", patch)
+				}
+			}
+			if got := len(recorder.Events) > 0; got != tt.wantEvent {
+				t.Errorf("Handle() events = %d, want event %v", len(recorder.Events), tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestAllowClaims(t *testing.T) {
+	resp := allowClaims.Handle(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Namespace: "default",
+	}})
+	if !resp.Allowed || len(resp.Patches) > 0 {
+		t.Errorf("Handle() = %+v, want allowed unchanged", resp)
+	}
+}
+
+var _ = Describe("ClaimRestorer", func() {
+	It("restores the claims of a StatefulSet from VolumeSnapshots", func() {
+		ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "restore-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+		Expect(k8sClient.Create(ctx, volumeSnapshot(ns.Name, "data-backup-0"))).To(Succeed())
+		Expect(k8sClient.Create(ctx, restoringStatefulSet(ns.Name, 1, map[string]string{
+			"spoditor.io/restore-claims": `{"claims":[{"name":"data","snapshot":"data-backup"}]}`,
+		}))).To(Succeed())
+
+		Eventually(func() ([]string, error) {
+			claims := &v1.PersistentVolumeClaimList{}
+			if err := k8sClient.List(ctx, claims, client.InNamespace(ns.Name)); err != nil {
+				return nil, err
+			}
+			var names []string
+			for _, pvc := range claims.Items {
+				names = append(names, pvc.Name)
+			}
+			sort.Strings(names)
+			return names, nil
+		}).Should(Equal([]string{"data-web-0"}))
+	})
+})
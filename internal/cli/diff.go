@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the hunks turning a into b line by line, surrounded by the given number of context lines
+func unifiedDiff(a, b string, context int) string {
+	lines := diffLines(strings.Split(strings.TrimSuffix(a, "\n"), "\n"), strings.Split(strings.TrimSuffix(b, "\n"), "\n"))
+	sb := &strings.Builder{}
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		// extend the hunk while changes are less than two contexts apart
+		end := i
+		for j := i; j < len(lines) && j <= end+2*context+1; j++ {
+			if lines[j].op != ' ' {
+				end = j
+			}
+		}
+		stop := end + context + 1
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+		oldStart, newStart := 1, 1
+		for _, l := range lines[:start] {
+			if l.op != '+' {
+				oldStart++
+			}
+			if l.op != '-' {
+				newStart++
+			}
+		}
+		oldLen, newLen := 0, 0
+		for _, l := range lines[start:stop] {
+			if l.op != '+' {
+				oldLen++
+			}
+			if l.op != '-' {
+				newLen++
+			}
+		}
+		fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldLen, newStart, newLen)
+		for _, l := range lines[start:stop] {
+			fmt.Fprintf(sb, "%c%s\n", l.op, l.text)
+		}
+		i = stop
+	}
+	return sb.String()
+}
+
+// diffLines computes the edit script of the longest common subsequence of a and b
+func diffLines(a, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{'+', b[j]})
+	}
+	return lines
+}
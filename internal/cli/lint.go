@@ -0,0 +1,260 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spoditor/spoditor/internal"
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// problem is a lint finding at a position of a manifest file
+type problem struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (p problem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// workload decodes the pod template of the workload kinds spoditor annotations are mistakenly put on
+type workload struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Template corev1.PodTemplateSpec `json:"template"`
+	} `json:"spec"`
+}
+
+var nonStatefulWorkloads = map[string]bool{
+	"Deployment": true,
+	"DaemonSet":  true,
+	"ReplicaSet": true,
+	"Job":        true,
+}
+
+// Lint checks the spoditor annotations of the workloads found in the manifests, and fails when any problem is found
+func Lint(args []string, builtins []annotation.Handler, out io.Writer) error {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	var files stringList
+	fs.Var(&files, "f", "Manifest to lint, - for stdin. Can be repeated, files can also be given as arguments.")
+	parseSyntax := syntaxFlags(fs)
+	parseHandlers := handlerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	syntax, err := parseSyntax()
+	if err != nil {
+		return err
+	}
+	handlers, err := parseHandlers(builtins)
+	if err != nil {
+		return err
+	}
+	files = append(files, fs.Args()...)
+	if len(files) == 0 {
+		return errors.New("no manifest specified")
+	}
+	docs, err := readFiles(files)
+	if err != nil {
+		return err
+	}
+	l := newLinter(handlers, syntax)
+	var problems []problem
+	for _, d := range docs {
+		problems = append(problems, l.lint(d)...)
+	}
+	for _, p := range problems {
+		fmt.Fprintln(out, p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problem(s) in spoditor annotations", len(problems))
+	}
+	return nil
+}
+
+type linter struct {
+	handlers []annotation.Handler
+	// syntax of the annotation keys
+	syntax    annotation.Syntax
+	collector annotation.QualifiedAnnotationCollector
+	// named handlers by the annotation name they claim
+	named map[string]annotation.Handler
+	// control annotations of the webhook and their value validation
+	controls map[string]func(string) error
+	// qualified annotations of the controllers and their value validation
+	qualified map[string]func(string) error
+}
+
+func newLinter(handlers []annotation.Handler, syntax annotation.Syntax) *linter {
+	l := &linter{
+		handlers:  handlers,
+		syntax:    syntax,
+		collector: annotation.NewCollector(syntax),
+		named:     map[string]annotation.Handler{},
+		controls: map[string]func(string) error{
+			internal.OnError: func(v string) error {
+				_, err := internal.ParseFailurePolicy(v)
+				return err
+			},
+			internal.DryRun: func(v string) error {
+				_, err := strconv.ParseBool(v)
+				return err
+			},
+			internal.QualifierOrdinals: func(v string) error {
+				_, err := internal.ParseOrdinalMode(v)
+				return err
+			},
+			internal.SkipClusterPolicies: func(v string) error {
+				if strings.TrimSpace(v) == "" {
+					return errors.New("expect policy names or *")
+				}
+				return nil
+			},
+		},
+		qualified: map[string]func(string) error{
+			internal.RestoreClaims: func(v string) error {
+				_, err := internal.ParseRestoreClaims(v)
+				return err
+			},
+		},
+	}
+	for _, h := range handlers {
+		if n, ok := h.(annotation.Named); ok {
+			l.named[n.Name()] = h
+		}
+	}
+	return l
+}
+
+func (l *linter) lint(d *document) []problem {
+	t, err := typeMeta(d)
+	if err != nil {
+		return []problem{{d.File, d.Line, fmt.Sprintf("invalid YAML: %v", err)}}
+	}
+	if !isStatefulSet(t) && !nonStatefulWorkloads[t.Kind] {
+		return nil
+	}
+	w := &workload{}
+	if err := yaml.Unmarshal(d.Data, w); err != nil {
+		return []problem{{d.File, d.Line, fmt.Sprintf("invalid %s: %v", t.Kind, err)}}
+	}
+
+	var problems []problem
+	for _, k := range l.sortedKeys(l.collector.Collect(&w.ObjectMeta)) {
+		problems = append(problems, problem{d.File, l.lineOf(d, k),
+			fmt.Sprintf("%s annotation on the %s itself has no effect, move it to .spec.template.metadata.annotations",
+				l.syntax.Key(k), t.Kind)})
+	}
+	annotations := l.collector.Collect(&w.Spec.Template.ObjectMeta)
+	if !isStatefulSet(t) {
+		for _, k := range l.sortedKeys(annotations) {
+			problems = append(problems, problem{d.File, l.lineOf(d, k),
+				fmt.Sprintf("%s annotation has no effect on a %s, only StatefulSet pods are mutated", l.syntax.Key(k), t.Kind)})
+		}
+		return problems
+	}
+
+	ranges := map[string][]annotation.QualifiedName{}
+	for _, k := range l.sortedKeys(annotations) {
+		key := l.syntax.Key(k)
+		line := l.lineOf(d, k)
+		p := func(format string, a ...interface{}) {
+			problems = append(problems, problem{d.File, line, fmt.Sprintf(format, a...)})
+		}
+		if validate, ok := l.controls[k.Name]; ok {
+			if k.Qualifier != "" {
+				p("%s annotation does not take a qualifier", key)
+			} else if err := validate(annotations[k]); err != nil {
+				p("%s annotation has invalid value: %v", key, err)
+			}
+			continue
+		}
+		if validate, ok := l.qualified[k.Name]; ok {
+			if _, err := annotation.ParseQualifier(k.Qualifier); err != nil {
+				p("%s annotation: %v", key, err)
+			} else if err := validate(annotations[k]); err != nil {
+				p("%s annotation can't be parsed: %v", key, err)
+			}
+			continue
+		}
+		h, ok := l.named[k.Name]
+		if !ok {
+			p("unknown annotation %s", key)
+			continue
+		}
+		r, err := annotation.ParseQualifier(k.Qualifier)
+		if err != nil {
+			p("%s annotation: %v", key, err)
+			continue
+		}
+		if _, err := h.GetParser().Parse(map[annotation.QualifiedName]string{k: annotations[k]}); err != nil {
+			p("%s annotation can't be parsed: %v", key, err)
+		}
+		if m, ok := h.(annotation.Merging); ok && m.MergesAnnotations() {
+			continue
+		}
+		for _, o := range ranges[k.Name] {
+			if or, _ := annotation.ParseQualifier(o.Qualifier); r.Overlaps(or) {
+				p("%s annotation overlaps with %s, only one of them is applied to the same pod", key, l.syntax.Key(o))
+			}
+		}
+		ranges[k.Name] = append(ranges[k.Name], k)
+	}
+	for _, h := range l.handlers {
+		if _, ok := h.(annotation.Named); ok {
+			continue
+		}
+		if _, err := h.GetParser().Parse(annotations); err != nil {
+			problems = append(problems, problem{d.File, d.Line, fmt.Sprintf("annotations can't be parsed by %T: %v", h, err)})
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems
+}
+
+func (l *linter) sortedKeys(m map[annotation.QualifiedName]string) []annotation.QualifiedName {
+	keys := make([]annotation.QualifiedName, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return l.syntax.Key(keys[i]) < l.syntax.Key(keys[j])
+	})
+	return keys
+}
+
+// lineOf returns the line of the first mapping key of the document parsed into the qualified name, whatever its
+// prefix or qualifier syntax, falling back to the document start
+func (l *linter) lineOf(d *document, k annotation.QualifiedName) int {
+	for i, line := range bytes.Split(d.Data, []byte("\n")) {
+		t := strings.TrimSpace(string(line))
+		j := strings.Index(t, ":")
+		if j == -1 {
+			continue
+		}
+		key := t[:j]
+		if u, err := strconv.Unquote(key); err == nil {
+			key = u
+		} else {
+			key = strings.Trim(key, "'")
+		}
+		if n, _, ok := l.syntax.Parse(key); ok && n == k {
+			return d.Line + i
+		}
+	}
+	return d.Line
+}
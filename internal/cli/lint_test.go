@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	"github.com/spoditor/spoditor/internal/annotation/security"
+	"github.com/spoditor/spoditor/internal/annotation/volumes"
+)
+
+func TestLinter_Lint(t *testing.T) {
+	tests := []struct {
+		name     string
+		syntax   *annotation.Syntax
+		manifest string
+		want     []int
+	}{
+		{
+			name:     "valid StatefulSet",
+			manifest: statefulSet,
+			want:     nil,
+		},
+		{
+			name: "annotations on a Deployment",
+			manifest: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: d
+spec:
+  template:
+    metadata:
+      annotations:
+        spoditor.io/mount-volume: "{}"
+`,
+			want: []int{9},
+		},
+		{
+			name: "invalid annotations",
+			manifest: `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+  annotations:
+    spoditor.io/on-error: deny
+spec:
+  template:
+    metadata:
+      annotations:
+        spoditor.io/mount-volume_0-2: "{}"
+        spoditor.io/mount-volume_2-: "{}"
+        spoditor.io/mount-volume_x: "{}"
+        "spoditor.io/mount-volume_5": "not json"
+        spoditor.io/mount-volumes: "{}"
+        spoditor.io/dry-run: "maybe"
+        spoditor.io/on-error_1: deny
+`,
+			// on the StatefulSet itself, overlapping qualifiers, invalid qualifier, invalid json and overlap,
+			// unknown name, invalid value, qualified control annotation
+			want: []int{6, 12, 13, 14, 14, 15, 16, 17},
+		},
+		{
+			name: "restore-claims annotations",
+			manifest: `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      annotations:
+        spoditor.io/restore-claims_0-2: '{"claims":[{"name":"data","snapshot":"backup"}]}'
+        spoditor.io/restore-claims_3-: '{"claims":[{"name":"data"}]}'
+`,
+			// neither snapshot nor cloneFrom
+			want: []int{10},
+		},
+		{
+			name: "alternative qualifier syntax",
+			manifest: `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      annotations:
+        spoditor.io/mount-volume.ordinals-from-3: "not json"
+`,
+			want: []int{9},
+		},
+		{
+			name: "overlapping qualifiers of a merging handler",
+			manifest: `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      annotations:
+        spoditor.io/security-context: '{"pod":{"fsGroup":2000}}'
+        spoditor.io/security-context_0: '{"pod":{"fsGroup":3000}}'
+`,
+			want: nil,
+		},
+		{
+			name:   "configured syntax",
+			syntax: &annotation.Syntax{Prefixes: []string{"spoditor.example.com/", "spoditor.io/"}, Separator: "__"},
+			manifest: `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      annotations:
+        spoditor.example.com/mount-volume__0-2: "{}"
+        spoditor.io/mount-volume__2: "not json"
+        spoditor.io/mount-volume_4: "{}"
+`,
+			// invalid json and overlap, unknown name with the default separator
+			want: []int{10, 10, 11},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syntax := annotation.DefaultSyntax
+			if tt.syntax != nil {
+				syntax = *tt.syntax
+			}
+			l := newLinter([]annotation.Handler{&volumes.MountHandler{}, &security.ContextHandler{}}, syntax)
+			docs, err := readDocuments("test.yaml", strings.NewReader(tt.manifest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for _, d := range docs {
+				for _, p := range l.lint(d) {
+					got = append(got, p.Line)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lint() problem lines = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLint_Handlers(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      annotations:
+        spoditor.io/mount-volume: "{}"
+        spoditor.io/sidecar: '{"image":"envoy"}'
+`
+	file := filepath.Join(t.TempDir(), "sts.yaml")
+	if err := os.WriteFile(file, []byte(manifest), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "unknown external annotation", wantErr: true},
+		{name: "external handler", args: []string{"--external-handler", "sidecar=http://sidecar.default.svc"}},
+		{
+			name:    "disabled handler",
+			args:    []string{"--external-handler", "sidecar=http://sidecar.default.svc", "--disable-handlers", "mount-volume"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := Lint(append(tt.args, file), []annotation.Handler{&volumes.MountHandler{}}, out)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Lint() error = %v, wantErr %v, output %s", err, tt.wantErr, out.String())
+			}
+		})
+	}
+}
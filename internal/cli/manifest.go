@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	"github.com/spoditor/spoditor/internal/annotation/external"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// document is a single YAML document of a manifest file
+type document struct {
+	File string
+	// Line is the 1-based line the document starts at in its file
+	Line int
+	Data []byte
+}
+
+func (d *document) position() string {
+	return fmt.Sprintf("%s:%d", d.File, d.Line)
+}
+
+// readDocuments splits a multi-document YAML stream on "---" separators, skipping empty documents
+func readDocuments(file string, r io.Reader) ([]*document, error) {
+	var docs []*document
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	cur := &document{File: file, Line: 1}
+	buf := &bytes.Buffer{}
+	flush := func() {
+		if len(bytes.TrimSpace(buf.Bytes())) > 0 {
+			cur.Data = append([]byte(nil), buf.Bytes()...)
+			docs = append(docs, cur)
+		}
+		buf.Reset()
+	}
+	line := 0
+	for s.Scan() {
+		line++
+		t := s.Text()
+		if t == "---" || strings.HasPrefix(t, "--- ") {
+			flush()
+			cur = &document{File: file, Line: line + 1}
+			continue
+		}
+		buf.WriteString(t)
+		buf.WriteByte('\n')
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return docs, nil
+}
+
+// readFiles reads all the documents of the given files, "-" standing for stdin
+func readFiles(files []string) ([]*document, error) {
+	var docs []*document
+	for _, f := range files {
+		d, err := readFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", f, err)
+		}
+		docs = append(docs, d...)
+	}
+	return docs, nil
+}
+
+func readFile(f string) ([]*document, error) {
+	if f == "-" {
+		return readDocuments(f, os.Stdin)
+	}
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readDocuments(f, file)
+}
+
+func typeMeta(d *document) (metav1.TypeMeta, error) {
+	t := metav1.TypeMeta{}
+	err := yaml.Unmarshal(d.Data, &t)
+	return t, err
+}
+
+func isStatefulSet(t metav1.TypeMeta) bool {
+	return t.Kind == "StatefulSet" && strings.HasPrefix(t.APIVersion, "apps/")
+}
+
+func decodeStatefulSet(d *document) (*appsv1.StatefulSet, error) {
+	ss := &appsv1.StatefulSet{}
+	if err := yaml.Unmarshal(d.Data, ss); err != nil {
+		return nil, fmt.Errorf("%s: invalid StatefulSet: %v", d.position(), err)
+	}
+	return ss, nil
+}
+
+// ordinalsStart reads the spec.ordinals.start of a StatefulSet, not part of the apps/v1 types of this client
+func ordinalsStart(d *document) (int, error) {
+	ss := &struct {
+		Spec struct {
+			Ordinals *struct {
+				Start int `json:"start"`
+			} `json:"ordinals"`
+		} `json:"spec"`
+	}{}
+	if err := yaml.Unmarshal(d.Data, ss); err != nil {
+		return 0, fmt.Errorf("%s: invalid StatefulSet: %v", d.position(), err)
+	}
+	if ss.Spec.Ordinals == nil {
+		return 0, nil
+	}
+	return ss.Spec.Ordinals.Start, nil
+}
+
+// syntaxFlags adds the flags of the annotation syntax configured on the manager, returning the parsed syntax
+func syntaxFlags(fs *flag.FlagSet) func() (annotation.Syntax, error) {
+	prefixes := fs.String("annotation-prefixes", annotation.Prefix,
+		"Comma separated prefixes of the annotation keys, as configured on the manager.")
+	separator := fs.String("qualifier-separator", annotation.Separator,
+		"The separator between an annotation name and its qualifier, as configured on the manager.")
+	return func() (annotation.Syntax, error) {
+		syntax := annotation.Syntax{Prefixes: splitList(*prefixes), Separator: *separator}
+		return syntax, syntax.Validate()
+	}
+}
+
+// handlerFlags adds the flags of the handlers configured on the manager, returning the enabled built-in and external
+// handlers in the order the webhook applies them
+func handlerFlags(fs *flag.FlagSet) func([]annotation.Handler) ([]annotation.Handler, error) {
+	var externals external.Handlers
+	fs.Var(&externals, "external-handler", "External handler, as configured on the manager. Can be repeated.")
+	enable := fs.String("enable-handlers", "",
+		"Comma separated annotation names of the only handlers to enable, as configured on the manager.")
+	disable := fs.String("disable-handlers", "",
+		"Comma separated annotation names of the handlers to disable, as configured on the manager.")
+	return func(builtins []annotation.Handler) ([]annotation.Handler, error) {
+		registry := annotation.NewRegistry()
+		for _, h := range builtins {
+			if err := registry.Register(h); err != nil {
+				return nil, err
+			}
+		}
+		for _, h := range externals {
+			if err := registry.Register(h); err != nil {
+				return nil, err
+			}
+		}
+		if err := registry.Configure(splitList(*enable), splitList(*disable)); err != nil {
+			return nil, err
+		}
+		return registry.Handlers()
+	}
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// stringList is a repeatable flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
@@ -0,0 +1,272 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/spoditor/spoditor/internal"
+	"github.com/spoditor/spoditor/internal/annotation"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+// Render prints the pods of each ordinal of the StatefulSets found in the manifests,
+// mutated by the given built-in handlers and the external ones the same way the webhook does
+func Render(args []string, builtins []annotation.Handler, out io.Writer) error {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	var files stringList
+	fs.Var(&files, "f", "StatefulSet manifest to render, - for stdin. Can be repeated.")
+	ordinals := fs.String("ordinals", "", "Ordinals to render, e.g. 0-4 or 0,2,5-. Defaults to all the replicas.")
+	diff := fs.Bool("diff", false, "Print the difference between the pod template and the mutated pods instead.")
+	parseSyntax := syntaxFlags(fs)
+	parseHandlers := handlerFlags(fs)
+	qualifierOrdinals := fs.String("qualifier-ordinals", string(internal.OrdinalModeAbsolute),
+		"Ordinal qualifiers are evaluated against, either absolute or logical, as configured on the manager.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	syntax, err := parseSyntax()
+	if err != nil {
+		return err
+	}
+	mode, err := internal.ParseOrdinalMode(*qualifierOrdinals)
+	if err != nil {
+		return err
+	}
+	handlers, err := parseHandlers(builtins)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no manifest specified with -f")
+	}
+	docs, err := readFiles(files)
+	if err != nil {
+		return err
+	}
+	r, err := newRenderer(handlers, syntax, mode)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, d := range docs {
+		t, err := typeMeta(d)
+		if err != nil || !isStatefulSet(t) {
+			continue
+		}
+		found = true
+		ss, err := decodeStatefulSet(d)
+		if err != nil {
+			return err
+		}
+		replicas := 1
+		if ss.Spec.Replicas != nil {
+			replicas = int(*ss.Spec.Replicas)
+		}
+		start, err := ordinalsStart(d)
+		if err != nil {
+			return err
+		}
+		r.argumentor.OrdinalsStart = internal.OrdinalsStartResolverFunc(func(context.Context, string, string) (int, error) {
+			return start, nil
+		})
+		ords, err := parseOrdinals(*ordinals, start, replicas)
+		if err != nil {
+			return err
+		}
+		for _, o := range ords {
+			pod := newStatefulSetPod(ss, o)
+			mutated, err := r.mutate(pod)
+			if err != nil {
+				return fmt.Errorf("%s: failed to render pod %s: %v", d.position(), pod.Name, err)
+			}
+			if *diff {
+				err = printDiff(out, pod, mutated)
+			} else {
+				err = printYAML(out, mutated)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return errors.New("no StatefulSet found in the manifests")
+	}
+	return nil
+}
+
+// renderer runs a PodArgumentor outside of the webhook server
+type renderer struct {
+	argumentor *internal.PodArgumentor
+}
+
+func newRenderer(handlers []annotation.Handler, syntax annotation.Syntax, mode internal.OrdinalMode) (*renderer, error) {
+	d, err := admission.NewDecoder(runtime.NewScheme())
+	if err != nil {
+		return nil, err
+	}
+	a := &internal.PodArgumentor{
+		SSPodId:           internal.DefaultSSPodIdentifier,
+		Collector:         annotation.NewCollector(syntax),
+		Syntax:            syntax,
+		FailurePolicy:     internal.FailurePolicyDeny,
+		QualifierOrdinals: mode,
+	}
+	if err := a.InjectDecoder(d); err != nil {
+		return nil, err
+	}
+	for _, h := range handlers {
+		a.Register(h)
+	}
+	return &renderer{argumentor: a}, nil
+}
+
+func (r *renderer) mutate(pod *corev1.Pod) (*corev1.Pod, error) {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	resp := r.argumentor.Handle(context.TODO(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if !resp.Allowed {
+		return nil, errors.New(resp.Result.Message)
+	}
+	if len(resp.Patches) == 0 {
+		return pod.DeepCopy(), nil
+	}
+	p, err := json.Marshal(resp.Patches)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonpatch.DecodePatch(p)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		return nil, err
+	}
+	mutated := &corev1.Pod{}
+	if err := json.Unmarshal(patched, mutated); err != nil {
+		return nil, err
+	}
+	return mutated, nil
+}
+
+// newStatefulSetPod synthesizes the pod of the given ordinal the way the StatefulSet controller does
+func newStatefulSetPod(ss *appsv1.StatefulSet, ordinal int) *corev1.Pod {
+	name := fmt.Sprintf("%s-%d", ss.Name, ordinal)
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: *ss.Spec.Template.ObjectMeta.DeepCopy(),
+		Spec:       *ss.Spec.Template.Spec.DeepCopy(),
+	}
+	pod.Name = name
+	pod.Namespace = ss.Namespace
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[appsv1.StatefulSetPodNameLabel] = name
+	pod.OwnerReferences = []metav1.OwnerReference{
+		*metav1.NewControllerRef(ss, appsv1.SchemeGroupVersion.WithKind("StatefulSet")),
+	}
+	pod.Spec.Hostname = name
+	pod.Spec.Subdomain = ss.Spec.ServiceName
+
+	if len(ss.Spec.VolumeClaimTemplates) > 0 {
+		claims := map[string]bool{}
+		var volumes []corev1.Volume
+		for _, c := range ss.Spec.VolumeClaimTemplates {
+			claims[c.Name] = true
+			volumes = append(volumes, corev1.Volume{
+				Name: c.Name,
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: fmt.Sprintf("%s-%s", c.Name, name),
+					},
+				},
+			})
+		}
+		for _, v := range pod.Spec.Volumes {
+			if !claims[v.Name] {
+				volumes = append(volumes, v)
+			}
+		}
+		pod.Spec.Volumes = volumes
+	}
+	return pod
+}
+
+// parseOrdinals parses a comma separated list of ordinals or ranges, an open upper bound stopping at the last replica
+func parseOrdinals(s string, start, replicas int) ([]int, error) {
+	var ordinals []int
+	if strings.TrimSpace(s) == "" {
+		for i := start; i < start+replicas; i++ {
+			ordinals = append(ordinals, i)
+		}
+		return ordinals, nil
+	}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		bounds := strings.SplitN(p, "-", 2)
+		min, err := strconv.Atoi(bounds[0])
+		if err != nil || min < 0 {
+			return nil, fmt.Errorf("invalid ordinals %q", p)
+		}
+		max := min
+		if len(bounds) == 2 {
+			if bounds[1] == "" {
+				max = start + replicas - 1
+			} else if max, err = strconv.Atoi(bounds[1]); err != nil || max < min {
+				return nil, fmt.Errorf("invalid ordinals %q", p)
+			}
+		}
+		for i := min; i <= max; i++ {
+			ordinals = append(ordinals, i)
+		}
+	}
+	return ordinals, nil
+}
+
+func printYAML(out io.Writer, pod *corev1.Pod) error {
+	b, err := yaml.Marshal(pod)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "---\n%s", b)
+	return err
+}
+
+func printDiff(out io.Writer, template, mutated *corev1.Pod) error {
+	a, err := yaml.Marshal(template)
+	if err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(mutated)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "--- %s (template)\n+++ %s (mutated)\n%s",
+		template.Name, mutated.Name, unifiedDiff(string(a), string(b), 3))
+	return err
+}
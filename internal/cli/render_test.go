@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spoditor/spoditor/internal"
+	"github.com/spoditor/spoditor/internal/annotation"
+	"github.com/spoditor/spoditor/internal/annotation/volumes"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const statefulSet = `apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+spec:
+  serviceName: nginx
+  replicas: 3
+  template:
+    metadata:
+      annotations:
+        spoditor.io/mount-volume_1-: |
+          {"volumes":[{"name":"my-volume","secret":{"secretName":"my-secret"}}],
+           "containers":[{"name":"nginx","volumeMounts":[{"name":"my-volume","mountPath":"/etc/secrets"}]}]}
+    spec:
+      containers:
+      - name: nginx
+        image: nginx
+  volumeClaimTemplates:
+  - metadata:
+      name: www
+`
+
+func TestParseOrdinals(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		start    int
+		replicas int
+		want     []int
+		wantErr  bool
+	}{
+		{name: "all replicas", s: "", replicas: 3, want: []int{0, 1, 2}},
+		{name: "range", s: "0-2", replicas: 1, want: []int{0, 1, 2}},
+		{name: "list", s: "0,2,4-", replicas: 6, want: []int{0, 2, 4, 5}},
+		{name: "invalid", s: "a-b", replicas: 3, wantErr: true},
+		{name: "reversed range", s: "3-1", replicas: 3, wantErr: true},
+		{name: "all replicas from start", s: "", start: 5, replicas: 2, want: []int{5, 6}},
+		{name: "open range from start", s: "6-", start: 5, replicas: 3, want: []int{6, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOrdinals(tt.s, tt.start, tt.replicas)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseOrdinals() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOrdinals() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	docs, err := readDocuments("sts.yaml", strings.NewReader(statefulSet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 || docs[1].Line != 6 {
+		t.Fatalf("readDocuments() got %d documents, want the StatefulSet at line 6", len(docs))
+	}
+	ss, err := decodeStatefulSet(docs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRenderer([]annotation.Handler{&volumes.MountHandler{}}, annotation.DefaultSyntax, internal.OrdinalModeAbsolute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		ordinal     int
+		wantVolumes []string
+	}{
+		{name: "excluded by qualifier", ordinal: 0, wantVolumes: []string{"www-web-0"}},
+		{name: "mounted", ordinal: 1, wantVolumes: []string{"www-web-1", "my-secret-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newStatefulSetPod(ss, tt.ordinal)
+			got, err := r.mutate(pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var volumes []string
+			for _, v := range got.Spec.Volumes {
+				if v.PersistentVolumeClaim != nil {
+					volumes = append(volumes, v.PersistentVolumeClaim.ClaimName)
+				}
+				if v.Secret != nil {
+					volumes = append(volumes, v.Secret.SecretName)
+				}
+			}
+			if !reflect.DeepEqual(volumes, tt.wantVolumes) {
+				t.Errorf("mutate() volumes = %v, want %v", volumes, tt.wantVolumes)
+			}
+		})
+	}
+}
+
+func TestRender_Syntax(t *testing.T) {
+	manifest := strings.NewReplacer("spoditor.io/mount-volume_1-", "example.com/mount-volume.ordinals-0").Replace(statefulSet)
+	file := filepath.Join(t.TempDir(), "sts.yaml")
+	if err := os.WriteFile(file, []byte(manifest), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		args       []string
+		wantSecret string
+		wantErr    bool
+	}{
+		{name: "default syntax", args: []string{"--ordinals", "0"}},
+		{
+			name:       "annotation prefixes",
+			args:       []string{"--ordinals", "0", "--annotation-prefixes", "example.com/,spoditor.io/"},
+			wantSecret: "my-secret-0",
+		},
+		{
+			name:       "logical qualifier ordinals",
+			args:       []string{"--ordinals", "0", "--annotation-prefixes", "example.com/", "--qualifier-ordinals", "logical"},
+			wantSecret: "my-secret-0",
+		},
+		{
+			name: "disabled handler",
+			args: []string{"--ordinals", "0", "--annotation-prefixes", "example.com/", "--disable-handlers", "mount-volume"},
+		},
+		{name: "unknown handler", args: []string{"--enable-handlers", "sidecar"}, wantErr: true},
+		{name: "invalid separator", args: []string{"--qualifier-separator", "-"}, wantErr: true},
+		{name: "invalid qualifier ordinals", args: []string{"--qualifier-ordinals", "relative"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := Render(append([]string{"-f", file}, tt.args...), []annotation.Handler{&volumes.MountHandler{}}, out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := strings.Contains(out.String(), "secretName: my-secret-0"); got != (tt.wantSecret != "") {
+				t.Errorf("Render() got\n%s\nwant secret %q", out.String(), tt.wantSecret)
+			}
+			if got := strings.Contains(out.String(), "example.com/applied:"); got != (tt.wantSecret != "") {
+				t.Errorf("Render() got\n%s\nwant the applied annotation of the first prefix", out.String())
+			}
+		})
+	}
+}
+
+func TestPrintDiff(t *testing.T) {
+	template := &corev1.Pod{Spec: corev1.PodSpec{Hostname: "web-0"}}
+	template.Name = "web-0"
+	mutated := template.DeepCopy()
+	mutated.Spec.Subdomain = "nginx"
+	out := &bytes.Buffer{}
+	if err := printDiff(out, template, mutated); err != nil {
+		t.Fatal(err)
+	}
+	want := "--- web-0 (template)\n+++ web-0 (mutated)\n" +
+		"@@ -4,4 +4,5 @@\n" +
+		" spec:\n" +
+		"   containers: null\n" +
+		"   hostname: web-0\n" +
+		"+  subdomain: nginx\n" +
+		" status: {}\n"
+	if out.String() != want {
+		t.Errorf("printDiff() got\n%s\nwant\n%s", out.String(), want)
+	}
+}
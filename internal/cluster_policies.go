@@ -0,0 +1,26 @@
+package internal
+
+import (
+	"strings"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+)
+
+const (
+	// SkipClusterPolicies lists the ClusterSpoditorPolicies a StatefulSet opts out of, comma separated, * for all of them
+	SkipClusterPolicies = "skip-cluster-policies"
+)
+
+// skipsClusterPolicy tells whether the spoditor.io/skip-cluster-policies annotation of a StatefulSet opts out of the policy
+func skipsClusterPolicy(annotations map[annotation.QualifiedName]string, name string) bool {
+	v, ok := annotations[annotation.QualifiedName{Name: SkipClusterPolicies}]
+	if !ok {
+		return false
+	}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "*" || s == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+// +kubebuilder:object:generate=true
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	componentconfig "k8s.io/component-base/config/v1alpha1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	APIVersion = "config.spoditor.io/v1alpha1"
+	Kind       = "SpoditorConfig"
+)
+
+// +kubebuilder:object:root=true
+
+// SpoditorConfig is the configuration file of the manager, the controller-runtime ControllerManagerConfig
+// extended with the options of spoditor
+type SpoditorConfig struct {
+	metav1.TypeMeta                        `json:",inline"`
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// OnError is the default failure policy, allow or deny
+	// +optional
+	OnError string `json:"onError,omitempty"`
+	// DryRun only reports the mutations by default
+	// +optional
+	DryRun *bool `json:"dryRun,omitempty"`
+	// QualifierOrdinals is the default ordinal qualifiers are evaluated against, absolute or logical
+	// +optional
+	QualifierOrdinals string `json:"qualifierOrdinals,omitempty"`
+	// NamespaceSelector the namespace of a pod must match to be mutated
+	// +optional
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// ObjectSelector a pod must match to be mutated
+	// +optional
+	ObjectSelector string `json:"objectSelector,omitempty"`
+	// ExcludedNamespaces are never mutated
+	// +optional
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	// OwnerRules recognize the pods of StatefulSet-like controllers
+	// +optional
+	OwnerRules []string `json:"ownerRules,omitempty"`
+	// AnnotationPrefixes of the annotations spoditor handles, the first one taking precedence
+	// +optional
+	AnnotationPrefixes []string `json:"annotationPrefixes,omitempty"`
+	// QualifierSeparator between an annotation name and its qualifier, only the alternative syntax when empty
+	// +optional
+	QualifierSeparator *string `json:"qualifierSeparator,omitempty"`
+	// Handlers enables, disables and registers annotation handlers
+	// +optional
+	Handlers Handlers `json:"handlers,omitempty"`
+	// EscalationNamespaces can escalate privileges with securityContext overrides, * for all of them
+	// +optional
+	EscalationNamespaces []string `json:"escalationNamespaces,omitempty"`
+	// GuardrailPermits are the privileged mutations spoditor may introduce into pods
+	// +optional
+	GuardrailPermits []string `json:"guardrailPermits,omitempty"`
+	// RestoreClaims runs the controller restoring the claims of StatefulSets from snapshots or clones
+	// +optional
+	RestoreClaims *bool `json:"restoreClaims,omitempty"`
+}
+
+// Handlers configures the annotation handlers of the webhook
+type Handlers struct {
+	// Enabled are the annotation names of the only handlers to enable, all when empty
+	// +optional
+	Enabled []string `json:"enabled,omitempty"`
+	// Disabled are the annotation names of the handlers to disable
+	// +optional
+	Disabled []string `json:"disabled,omitempty"`
+	// External handlers, as <annotation name>=<url>[,timeout=<duration>][,priority=<priority>][,dependsOn=<name>]...
+	// +optional
+	External []string `json:"external,omitempty"`
+}
+
+// Load reads and validates a configuration file, rejecting unknown fields
+func Load(path string) (*SpoditorConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	c := &SpoditorConfig{}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if c.APIVersion != APIVersion || c.Kind != Kind {
+		return nil, fmt.Errorf("invalid config file %s: expect apiVersion %s and kind %s", path, APIVersion, Kind)
+	}
+	if c.LeaderElection == nil {
+		c.LeaderElection = &componentconfig.LeaderElectionConfiguration{}
+	}
+	return c, nil
+}
+
+// flagValues are the values of the configuration by the name of the flag they default,
+// several values for repeatable flags
+func (c *SpoditorConfig) flagValues() map[string][]string {
+	v := map[string][]string{}
+	set := func(name, value string) {
+		if value != "" {
+			v[name] = []string{value}
+		}
+	}
+	set("metrics-bind-address", c.Metrics.BindAddress)
+	set("health-probe-bind-address", c.Health.HealthProbeBindAddress)
+	if c.Webhook.Port != nil {
+		set("webhook-port", strconv.Itoa(*c.Webhook.Port))
+	}
+	set("cert-dir", c.Webhook.CertDir)
+	if c.LeaderElection != nil {
+		if c.LeaderElection.LeaderElect != nil {
+			set("leader-elect", strconv.FormatBool(*c.LeaderElection.LeaderElect))
+		}
+		set("leader-election-id", c.LeaderElection.ResourceName)
+	}
+	set("on-error", c.OnError)
+	if c.DryRun != nil {
+		set("dry-run", strconv.FormatBool(*c.DryRun))
+	}
+	set("qualifier-ordinals", c.QualifierOrdinals)
+	set("namespace-selector", c.NamespaceSelector)
+	set("object-selector", c.ObjectSelector)
+	if c.ExcludedNamespaces != nil {
+		// an empty list excludes no namespace rather than the default ones
+		v["excluded-namespaces"] = []string{strings.Join(c.ExcludedNamespaces, ",")}
+	}
+	set("annotation-prefixes", strings.Join(c.AnnotationPrefixes, ","))
+	if c.QualifierSeparator != nil {
+		v["qualifier-separator"] = []string{*c.QualifierSeparator}
+	}
+	set("escalation-namespaces", strings.Join(c.EscalationNamespaces, ","))
+	set("guardrail-permits", strings.Join(c.GuardrailPermits, ","))
+	if c.RestoreClaims != nil {
+		set("restore-claims", strconv.FormatBool(*c.RestoreClaims))
+	}
+	set("enable-handlers", strings.Join(c.Handlers.Enabled, ","))
+	set("disable-handlers", strings.Join(c.Handlers.Disabled, ","))
+	if len(c.OwnerRules) > 0 {
+		v["owner-rule"] = c.OwnerRules
+	}
+	if len(c.Handlers.External) > 0 {
+		v["external-handler"] = c.Handlers.External
+	}
+	return v
+}
+
+// WithoutFlags copies the configuration without the controller-runtime options it sets through their flags, so that
+// merging it into the manager options, which only fills unset options, doesn't override an explicit flag set to
+// the zero value, e.g. --leader-elect=false over leaderElection.leaderElect: true
+func (c *SpoditorConfig) WithoutFlags() *SpoditorConfig {
+	w := c.DeepCopy()
+	w.Metrics.BindAddress = ""
+	w.Health.HealthProbeBindAddress = ""
+	w.Webhook.Port = nil
+	w.Webhook.CertDir = ""
+	if w.LeaderElection != nil {
+		w.LeaderElection.LeaderElect = nil
+		w.LeaderElection.ResourceName = ""
+	}
+	return w
+}
+
+// SetFlags sets the flags not given on the command line to the values of the configuration,
+// so that command line flags override the configuration file and values are validated the same way
+func SetFlags(fs *flag.FlagSet, c *SpoditorConfig) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for name, values := range c.flagValues() {
+		if given[name] {
+			continue
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("no flag %s for the configuration", name)
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid configuration of %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			content: `apiVersion: config.spoditor.io/v1alpha1
+kind: SpoditorConfig
+webhook:
+  port: 9443
+onError: deny
+`,
+		},
+		{
+			name: "unknown field",
+			content: `apiVersion: config.spoditor.io/v1alpha1
+kind: SpoditorConfig
+onErrors: deny
+`,
+			wantErr: true,
+		},
+		{
+			name: "controller-runtime kind",
+			content: `apiVersion: controller-runtime.sigs.k8s.io/v1alpha1
+kind: ControllerManagerConfig
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Load(writeConfig(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if c != nil && c.LeaderElection == nil {
+				t.Errorf("Load() leader election is nil")
+			}
+		})
+	}
+}
+
+func TestSetFlags(t *testing.T) {
+	c, err := Load(writeConfig(t, `apiVersion: config.spoditor.io/v1alpha1
+kind: SpoditorConfig
+webhook:
+  port: 8443
+leaderElection:
+  leaderElect: true
+onError: deny
+excludedNamespaces: []
+ownerRules:
+- apps.kruise.io/StatefulSet
+- example.com/Cluster
+handlers:
+  disabled:
+  - mount-volume
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("webhook-port", 9443, "")
+	leaderElect := fs.Bool("leader-elect", false, "")
+	onError := fs.String("on-error", "allow", "")
+	excluded := fs.String("excluded-namespaces", "kube-system", "")
+	disabled := fs.String("disable-handlers", "", "")
+	var ownerRules repeated
+	fs.Var(&ownerRules, "owner-rule", "")
+	if err := fs.Parse([]string{"--on-error=allow"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetFlags(fs, c); err != nil {
+		t.Fatal(err)
+	}
+	if *port != 8443 || !*leaderElect || *excluded != "" || *disabled != "mount-volume" {
+		t.Errorf("SetFlags() port = %v, leader-elect = %v, excluded = %q, disabled = %q",
+			*port, *leaderElect, *excluded, *disabled)
+	}
+	if *onError != "allow" {
+		t.Errorf("SetFlags() on-error = %v, want the command line value", *onError)
+	}
+	if want := (repeated{"apps.kruise.io/StatefulSet", "example.com/Cluster"}); !reflect.DeepEqual(ownerRules, want) {
+		t.Errorf("SetFlags() owner rules = %v, want %v", ownerRules, want)
+	}
+
+	if err := SetFlags(flag.NewFlagSet("empty", flag.ContinueOnError), c); err == nil {
+		t.Errorf("SetFlags() expected error for missing flag")
+	}
+}
+
+func TestSpoditorConfig_WithoutFlags(t *testing.T) {
+	c, err := Load(writeConfig(t, `apiVersion: config.spoditor.io/v1alpha1
+kind: SpoditorConfig
+webhook:
+  certDir: /etc/certs
+leaderElection:
+  leaderElect: true
+  leaseDuration: 30s
+syncPeriod: 1h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	leaderElect := fs.Bool("leader-elect", false, "")
+	certDir := fs.String("cert-dir", "", "")
+	if err := fs.Parse([]string{"--leader-elect=false", "--cert-dir="}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetFlags(fs, c); err != nil {
+		t.Fatal(err)
+	}
+	options, err := ctrl.Options{LeaderElection: *leaderElect, CertDir: *certDir}.AndFrom(c.WithoutFlags())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.LeaderElection || options.CertDir != "" {
+		t.Errorf("AndFrom() leader election = %v, cert dir = %q, want the explicit flags", options.LeaderElection, options.CertDir)
+	}
+	if options.LeaseDuration == nil || *options.LeaseDuration != 30*time.Second || options.SyncPeriod == nil || *options.SyncPeriod != time.Hour {
+		t.Errorf("AndFrom() lease duration = %v, sync period = %v, want the config file values", options.LeaseDuration, options.SyncPeriod)
+	}
+	if c.LeaderElection.LeaderElect == nil || c.Webhook.CertDir == "" {
+		t.Errorf("WithoutFlags() modified the configuration")
+	}
+}
+
+type repeated []string
+
+func (r *repeated) String() string {
+	return ""
+}
+
+func (r *repeated) Set(s string) error {
+	*r = append(*r, s)
+	return nil
+}
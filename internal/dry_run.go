@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	DryRun = "dry-run"
+	// DryRunPatch is the name of the pod annotation recording the patch a dry-run would have applied
+	DryRunPatch = "dry-run-patch"
+)
+
+// resolveDryRun lets the spoditor.io/dry-run annotation of a StatefulSet override the global dry-run mode
+func resolveDryRun(global bool, annotations map[annotation.QualifiedName]string) (bool, error) {
+	v, ok := annotations[annotation.QualifiedName{Name: DryRun}]
+	if !ok {
+		return global, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return global, fmt.Errorf("invalid %s annotation %q: %v", DryRun, v, err)
+	}
+	return b, nil
+}
+
+// dryRunRequest tells whether the API server won't persist the object of the request, the webhooks declaring no side
+// effects for them
+func dryRunRequest(request admission.Request) bool {
+	return request.DryRun != nil && *request.DryRun
+}
+
+// dryRunResponse admits the original pod, only recording the patch computed from the mutated pod
+// as an annotation, an admission warning and, when a recorder is available and the request persists the pod, an event
+func (r *PodArgumentor) dryRunResponse(request admission.Request, original *v1.Pod, mutated []byte) (admission.Response, error) {
+	raw := request.Object.Raw
+	patches := admission.PatchResponseFromRaw(raw, mutated).Patches
+	if len(patches) == 0 {
+		return admission.Allowed("dry-run: nothing to mutate"), nil
+	}
+	p, err := json.Marshal(patches)
+	if err != nil {
+		return admission.Response{}, err
+	}
+	log.Info("dry-run computed patch", "patch", string(p))
+
+	original = original.DeepCopy()
+	if original.Annotations == nil {
+		original.Annotations = map[string]string{}
+	}
+	original.Annotations[r.key(DryRunPatch)] = string(p)
+	marshaledPod, err := json.Marshal(original)
+	if err != nil {
+		return admission.Response{}, err
+	}
+	if r.Recorder != nil && !dryRunRequest(request) {
+		r.Recorder.Eventf(original, v1.EventTypeNormal, "DryRun", "spoditor would apply patch %s", p)
+	}
+	return admission.PatchResponseFromRaw(raw, marshaledPod).
+		WithWarnings(fmt.Sprintf("spoditor dry-run, would apply patch %s", p)), nil
+}
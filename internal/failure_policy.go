@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	OnError = "on-error"
+)
+
+// FailurePolicy decides how the webhook answers when a StatefulSet pod can't be mutated
+type FailurePolicy string
+
+const (
+	FailurePolicyAllow FailurePolicy = "allow"
+	FailurePolicyDeny  FailurePolicy = "deny"
+)
+
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch p := FailurePolicy(s); p {
+	case FailurePolicyAllow, FailurePolicyDeny:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown failure policy %q, expect %q or %q", s, FailurePolicyAllow, FailurePolicyDeny)
+	}
+}
+
+// Respond turns a mutation failure into an admission response according to the policy
+func (p FailurePolicy) Respond(msg string) admission.Response {
+	if p == FailurePolicyDeny {
+		return admission.Denied(msg)
+	}
+	return admission.Allowed(msg)
+}
+
+// resolveFailurePolicy lets the spoditor.io/on-error annotation of a StatefulSet override the global policy
+func resolveFailurePolicy(global FailurePolicy, annotations map[annotation.QualifiedName]string) (FailurePolicy, error) {
+	if global == "" {
+		global = FailurePolicyAllow
+	}
+	v, ok := annotations[annotation.QualifiedName{Name: OnError}]
+	if !ok {
+		return global, nil
+	}
+	p, err := ParseFailurePolicy(v)
+	if err != nil {
+		return global, err
+	}
+	return p, nil
+}
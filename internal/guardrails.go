@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// Permits of the guardrails, a capability being permitted as capability=<name>, capability=* for all of them
+const (
+	PermitHostPath       = "host-path"
+	PermitPrivileged     = "privileged"
+	PermitHostNamespaces = "host-namespaces"
+	PermitCapability     = "capability="
+)
+
+// Guardrails block the mutations introducing privileges into a pod, whoever can edit a StatefulSet template being
+// able to make spoditor inject them. Only what the handlers and policies add is checked, not what the pod template
+// already has
+type Guardrails struct {
+	HostPath       bool
+	Privileged     bool
+	HostNamespaces bool
+	// Capabilities permitted to be added, * for all of them
+	Capabilities []string
+	// ExemptNamespaces are not checked, * for all of them
+	ExemptNamespaces []string
+}
+
+// ParseGuardrails permits the comma separated host-path, privileged, host-namespaces and capability=<name> mutations
+func ParseGuardrails(s string) (*Guardrails, error) {
+	g := &Guardrails{}
+	for _, p := range strings.Split(s, ",") {
+		switch p = strings.TrimSpace(p); {
+		case p == "":
+		case p == PermitHostPath:
+			g.HostPath = true
+		case p == PermitPrivileged:
+			g.Privileged = true
+		case p == PermitHostNamespaces:
+			g.HostNamespaces = true
+		case strings.HasPrefix(p, PermitCapability) && len(p) > len(PermitCapability):
+			g.Capabilities = append(g.Capabilities, strings.TrimPrefix(p, PermitCapability))
+		default:
+			return nil, fmt.Errorf("unknown guardrail permit %q, expect %s, %s, %s or %s<capability>",
+				p, PermitHostPath, PermitPrivileged, PermitHostNamespaces, PermitCapability)
+		}
+	}
+	return g, nil
+}
+
+// Check lists the violations of the mutation of the original pod spec into the mutated one
+func (g *Guardrails) Check(namespace string, original, mutated *v1.PodSpec) []string {
+	for _, n := range g.ExemptNamespaces {
+		if n == "*" || n == namespace {
+			return nil
+		}
+	}
+	var violations []string
+	if !g.HostPath {
+		for _, v := range mutated.Volumes {
+			if v.HostPath != nil && !hasHostPath(original.Volumes, v) {
+				violations = append(violations, fmt.Sprintf("hostPath volume %s", v.Name))
+			}
+		}
+	}
+	if !g.HostNamespaces {
+		for _, n := range []struct {
+			name              string
+			original, mutated bool
+		}{
+			{"hostNetwork", original.HostNetwork, mutated.HostNetwork},
+			{"hostPID", original.HostPID, mutated.HostPID},
+			{"hostIPC", original.HostIPC, mutated.HostIPC},
+		} {
+			if n.mutated && !n.original {
+				violations = append(violations, n.name)
+			}
+		}
+	}
+	check := func(kind string, mutated []v1.Container, original []v1.Container) {
+		for _, c := range mutated {
+			var o *v1.Container
+			for i := range original {
+				if original[i].Name == c.Name {
+					o = &original[i]
+				}
+			}
+			if !g.Privileged && isPrivileged(&c) && (o == nil || !isPrivileged(o)) {
+				violations = append(violations, fmt.Sprintf("privileged %s %s", kind, c.Name))
+			}
+			for _, capability := range addedCapabilities(&c) {
+				if !g.permitsCapability(capability) && (o == nil || !hasCapability(addedCapabilities(o), capability)) {
+					violations = append(violations, fmt.Sprintf("capability %s of %s %s", capability, kind, c.Name))
+				}
+			}
+		}
+	}
+	check("init container", mutated.InitContainers, original.InitContainers)
+	check("container", mutated.Containers, original.Containers)
+	return violations
+}
+
+func (g *Guardrails) permitsCapability(c v1.Capability) bool {
+	for _, p := range g.Capabilities {
+		if p == "*" || v1.Capability(p) == c {
+			return true
+		}
+	}
+	return false
+}
+
+func hasHostPath(volumes []v1.Volume, v v1.Volume) bool {
+	for _, o := range volumes {
+		if o.HostPath != nil && equality.Semantic.DeepEqual(o.HostPath, v.HostPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrivileged(c *v1.Container) bool {
+	return c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged
+}
+
+func addedCapabilities(c *v1.Container) []v1.Capability {
+	if c.SecurityContext == nil || c.SecurityContext.Capabilities == nil {
+		return nil
+	}
+	return c.SecurityContext.Capabilities.Add
+}
+
+func hasCapability(caps []v1.Capability, c v1.Capability) bool {
+	for _, o := range caps {
+		if o == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/spoditor/spoditor/internal/annotation/volumes"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestGuardrails_Check(t *testing.T) {
+	hostPath := v1.Volume{Name: "host", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/var/run"}}}
+	container := func(name string, privileged bool, caps ...v1.Capability) v1.Container {
+		c := v1.Container{Name: name, SecurityContext: &v1.SecurityContext{Capabilities: &v1.Capabilities{Add: caps}}}
+		if privileged {
+			c.SecurityContext.Privileged = &privileged
+		}
+		return c
+	}
+	original := &v1.PodSpec{
+		Volumes:    []v1.Volume{hostPath},
+		Containers: []v1.Container{container("web", false, "NET_BIND_SERVICE")},
+	}
+	tests := []struct {
+		name       string
+		guardrails *Guardrails
+		namespace  string
+		mutate     func(*v1.PodSpec)
+		want       []string
+	}{
+		{
+			name:       "existing privileges are kept",
+			guardrails: &Guardrails{},
+			mutate:     func(s *v1.PodSpec) { s.Containers[0].Image = "nginx" },
+		},
+		{
+			name:       "introduced privileges are blocked",
+			guardrails: &Guardrails{},
+			mutate: func(s *v1.PodSpec) {
+				s.Volumes = append(s.Volumes, v1.Volume{Name: "root", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/"}}})
+				s.HostNetwork = true
+				s.HostPID = true
+				s.Containers[0].SecurityContext.Capabilities.Add = append(s.Containers[0].SecurityContext.Capabilities.Add, "SYS_ADMIN")
+				s.Containers = append(s.Containers, container("sidecar", true))
+				s.InitContainers = append(s.InitContainers, container("init", false, "NET_ADMIN"))
+			},
+			want: []string{
+				"hostPath volume root", "hostNetwork", "hostPID",
+				"capability NET_ADMIN of init container init",
+				"capability SYS_ADMIN of container web", "privileged container sidecar",
+			},
+		},
+		{
+			name:       "changed hostPath is blocked",
+			guardrails: &Guardrails{},
+			mutate:     func(s *v1.PodSpec) { s.Volumes[0].HostPath = &v1.HostPathVolumeSource{Path: "/"} },
+			want:       []string{"hostPath volume host"},
+		},
+		{
+			name:       "permitted privileges",
+			guardrails: &Guardrails{HostPath: true, Privileged: true, HostNamespaces: true, Capabilities: []string{"SYS_ADMIN"}},
+			mutate: func(s *v1.PodSpec) {
+				s.Volumes = append(s.Volumes, v1.Volume{Name: "root", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/"}}})
+				s.HostIPC = true
+				s.Containers = append(s.Containers, container("sidecar", true, "SYS_ADMIN", "NET_ADMIN"))
+			},
+			want: []string{"capability NET_ADMIN of container sidecar"},
+		},
+		{
+			name:       "exempt namespace",
+			guardrails: &Guardrails{ExemptNamespaces: []string{"maintenance"}},
+			namespace:  "maintenance",
+			mutate:     func(s *v1.PodSpec) { s.HostNetwork = true },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mutated := original.DeepCopy()
+			tt.mutate(mutated)
+			if got := tt.guardrails.Check(tt.namespace, original, mutated); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGuardrails(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    *Guardrails
+		wantErr bool
+	}{
+		{name: "nothing permitted", s: "", want: &Guardrails{}},
+		{
+			name: "permits",
+			s:    "host-path, privileged,host-namespaces,capability=NET_ADMIN,capability=*",
+			want: &Guardrails{HostPath: true, Privileged: true, HostNamespaces: true, Capabilities: []string{"NET_ADMIN", "*"}},
+		},
+		{name: "unknown permit", s: "host-network", wantErr: true},
+		{name: "no capability", s: "capability=", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseGuardrails(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGuardrails() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) && !tt.wantErr {
+				t.Errorf("ParseGuardrails() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodArgumentor_Handle_Guardrails(t *testing.T) {
+	hostMount := map[string]string{
+		"spoditor.io/mount-volume": `{"volumes":[{"name":"docker","hostPath":{"path":"/var/run/docker.sock"}}],` +
+			`"containers":[{"name":"nginx","volumeMounts":[{"name":"docker","mountPath":"/var/run/docker.sock"}]}]}`,
+	}
+	tests := []struct {
+		name        string
+		guardrails  *Guardrails
+		wantAllowed bool
+		wantPatched bool
+	}{
+		{name: "no guardrails", wantAllowed: true, wantPatched: true},
+		{name: "hostPath blocked", guardrails: &Guardrails{}, wantAllowed: false},
+		{name: "hostPath permitted", guardrails: &Guardrails{HostPath: true}, wantAllowed: true, wantPatched: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newPodArgumentor(t, FailurePolicyDeny, &volumes.MountHandler{})
+			r.Guardrails = tt.guardrails
+			got := r.Handle(context.TODO(), newPodRequest(t, newSSPod(hostMount)))
+			if got.Allowed != tt.wantAllowed {
+				t.Errorf("Handle() allowed = %v, want %v, result %v", got.Allowed, tt.wantAllowed, got.Result)
+			}
+			if patched := len(got.Patches) > 0; patched != tt.wantPatched {
+				t.Errorf("Handle() patched = %v, want %v", patched, tt.wantPatched)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	QualifierOrdinals = "qualifier-ordinals"
+)
+
+// OrdinalMode decides which ordinal qualifiers are evaluated against
+type OrdinalMode string
+
+const (
+	// OrdinalModeAbsolute evaluates qualifiers against the ordinal suffixing the pod name
+	OrdinalModeAbsolute OrdinalMode = "absolute"
+	// OrdinalModeLogical evaluates qualifiers against the ordinal relative to the StatefulSet spec.ordinals.start
+	OrdinalModeLogical OrdinalMode = "logical"
+)
+
+func ParseOrdinalMode(s string) (OrdinalMode, error) {
+	switch m := OrdinalMode(s); m {
+	case OrdinalModeAbsolute, OrdinalModeLogical:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown ordinal mode %q, expect %q or %q", s, OrdinalModeAbsolute, OrdinalModeLogical)
+	}
+}
+
+// resolveOrdinalMode lets the spoditor.io/qualifier-ordinals annotation of a StatefulSet override the global mode
+func resolveOrdinalMode(global OrdinalMode, annotations map[annotation.QualifiedName]string) (OrdinalMode, error) {
+	if global == "" {
+		global = OrdinalModeAbsolute
+	}
+	v, ok := annotations[annotation.QualifiedName{Name: QualifierOrdinals}]
+	if !ok {
+		return global, nil
+	}
+	return ParseOrdinalMode(v)
+}
+
+// OrdinalsStartResolver returns the spec.ordinals.start of a StatefulSet
+type OrdinalsStartResolver interface {
+	OrdinalsStart(ctx context.Context, namespace, name string) (int, error)
+}
+
+type OrdinalsStartResolverFunc func(context.Context, string, string) (int, error)
+
+func (f OrdinalsStartResolverFunc) OrdinalsStart(ctx context.Context, namespace, name string) (int, error) {
+	return f(ctx, namespace, name)
+}
+
+var _ OrdinalsStartResolver = OrdinalsStartResolverFunc(nil)
+
+var statefulSetGVK = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}
+
+// NewStatefulSetOrdinalsStart reads the spec.ordinals.start of apps/v1 StatefulSets, which is 0 when unset.
+// The reader is meant to be the cache of the manager, the lookup happening on every admission of their pods.
+// A missing StatefulSet is an error, e.g. when the cache lags behind, so that the failure policy applies
+func NewStatefulSetOrdinalsStart(reader client.Reader) OrdinalsStartResolverFunc {
+	return func(ctx context.Context, namespace, name string) (int, error) {
+		ss := &unstructured.Unstructured{}
+		ss.SetGroupVersionKind(statefulSetGVK)
+		if err := reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, ss); err != nil {
+			return 0, err
+		}
+		start, _, err := unstructured.NestedInt64(ss.Object, "spec", "ordinals", "start")
+		if err != nil {
+			return 0, err
+		}
+		return int(start), nil
+	}
+}
+
+// newPodInfo computes the ordinals of the pod according to the mode, looking up the ordinals start for the pods of
+// apps/v1 StatefulSets, and in logical mode for the pods without controller too
+func (r *PodArgumentor) newPodInfo(ctx context.Context, mode OrdinalMode, pod *corev1.Pod, namespace, ss string, ordinal int) (*annotation.PodInfo, error) {
+	info := annotation.NewPodInfo(ordinal)
+	info.Name = pod.Name
+	info.Namespace = namespace
+	info.StatefulSet = ss
+	owner := metav1.GetControllerOf(pod)
+	if r.OrdinalsStart != nil && ((owner == nil && mode == OrdinalModeLogical) || (owner != nil && StatefulSetOwnerRule.matches(owner))) {
+		start, err := r.OrdinalsStart.OrdinalsStart(ctx, namespace, ss)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ordinals start of statefulset %s: %v", ss, err)
+		}
+		info.LogicalOrdinal = ordinal - start
+	}
+	if mode == OrdinalModeLogical {
+		info.Ordinal = info.LogicalOrdinal
+	}
+	return info, nil
+}
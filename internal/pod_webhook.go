@@ -3,16 +3,25 @@ package internal
 import (
 	"context"
 	"fmt"
+	"strings"
 
+	"github.com/spoditor/spoditor/api/v1alpha1"
 	"github.com/spoditor/spoditor/internal/annotation"
+	"github.com/spoditor/spoditor/internal/policies"
+	admissionv1 "k8s.io/api/admission/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 // +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create;update,versions=v1,name=mpod.spoditor.io,admissionReviewVersions={v1,v1beta1}
 
 // log is for logging in this package.
@@ -25,6 +34,37 @@ type PodArgumentor struct {
 	SSPodId   SSPodIdentifier
 	handlers  []annotation.Handler
 	Collector annotation.QualifiedAnnotationCollector
+	// Syntax of the annotations spoditor writes, e.g. spoditor.io/applied, annotation.DefaultSyntax when unset.
+	// The written annotations of all its prefixes are recognized
+	Syntax annotation.Syntax
+	// FailurePolicy is the default answer when a StatefulSet pod fails to be mutated,
+	// a StatefulSet can override it with the spoditor.io/on-error annotation
+	FailurePolicy FailurePolicy
+	// DryRun only reports the mutations instead of applying them,
+	// a StatefulSet can override it with the spoditor.io/dry-run annotation
+	DryRun bool
+	// Recorder is optional, when set dry-run patches are also recorded as events on the pod, except for the requests
+	// the API server doesn't persist
+	Recorder record.EventRecorder
+	// QualifierOrdinals is the default ordinal qualifiers are evaluated against,
+	// a StatefulSet can override it with the spoditor.io/qualifier-ordinals annotation
+	QualifierOrdinals OrdinalMode
+	// OrdinalsStart is optional, without it the logical ordinal of a pod is its absolute ordinal. It is only called for
+	// the pods of apps/v1 StatefulSets, and of no controller in logical mode
+	OrdinalsStart OrdinalsStartResolver
+	// Scope is optional, without it the pods of all the namespaces are mutated
+	Scope *Scope
+	// Policies is optional, the SpoditorPolicies it provides are applied after the annotations,
+	// then the ClusterSpoditorPolicies as defaults
+	Policies PolicySource
+	// Guardrails are optional, when set the mutations they block fail according to the failure policy
+	Guardrails *Guardrails
+}
+
+// PolicySource provides the SpoditorPolicies and ClusterSpoditorPolicies targeting a StatefulSet
+type PolicySource interface {
+	Policies(ctx context.Context, namespace, statefulSet string) ([]*v1alpha1.SpoditorPolicy, error)
+	ClusterPolicies(ctx context.Context, namespace, statefulSet string) ([]*v1alpha1.ClusterSpoditorPolicy, error)
 }
 
 func (r *PodArgumentor) Handle(c context.Context, request admission.Request) admission.Response {
@@ -35,30 +75,168 @@ func (r *PodArgumentor) Handle(c context.Context, request admission.Request) adm
 	}
 
 	log.Info("start handling pod", "pod", pod)
+	namespace := pod.Namespace
+	if namespace == "" {
+		namespace = request.Namespace
+	}
+	if r.Scope != nil {
+		in, reason, err := r.Scope.Includes(c, namespace, pod.Labels)
+		if err != nil {
+			return admission.Allowed(fmt.Sprintf("ignore pod of unknown scope %v", err))
+		}
+		if !in {
+			log.Info("pod out of scope", "reason", reason)
+			return admission.Allowed(fmt.Sprintf("ignore pod out of scope, %s", reason))
+		}
+	}
 	// mutate the fields in pod
 	ss, ordinal, err := r.SSPodId.Extract(pod)
 	if err != nil {
 		return admission.Allowed(fmt.Sprintf("ignore none-statefulset pod %v", err))
 	}
 	log.Info("found statefulset pod", "statefulset name", ss, "ordinal", ordinal)
+	var warnings []string
+	for _, key := range r.keys(Applied) {
+		a, ok := pod.Annotations[key]
+		if !ok {
+			continue
+		}
+		if request.Operation != admissionv1.Create {
+			log.Info("pod has already been mutated", "applied", a)
+			return admission.Allowed("pod has already been mutated")
+		}
+		// a pod being created can't have been mutated yet, its template carries the annotation,
+		// e.g. when copied from the output of kubectl get -o yaml
+		log.Info("ignore applied annotation of the pod template", "applied", a)
+		delete(pod.Annotations, key)
+		warnings = append(warnings, fmt.Sprintf("spoditor ignored the %s annotation of the pod template", key))
+	}
+
+	annotations := r.Collector.Collect(pod)
+	policy, err := resolveFailurePolicy(r.FailurePolicy, annotations)
+	if err != nil {
+		return policy.Respond(fmt.Sprintf("invalid %s annotation %v", OnError, err))
+	}
+	dryRun, err := resolveDryRun(r.DryRun, annotations)
+	if err != nil {
+		return policy.Respond(err.Error())
+	}
+	mode, err := resolveOrdinalMode(r.QualifierOrdinals, annotations)
+	if err != nil {
+		return policy.Respond(fmt.Sprintf("invalid %s annotation %v", QualifierOrdinals, err))
+	}
+	info, err := r.newPodInfo(c, mode, pod, namespace, ss, ordinal)
+	if err != nil {
+		return policy.Respond(err.Error())
+	}
+	log.Info("resolved pod ordinals", "mode", mode, "absolute", info.AbsoluteOrdinal, "logical", info.LogicalOrdinal)
+	original := pod.DeepCopy()
+	summary := &AppliedSummary{Version: Version, Ordinal: info.Ordinal}
 
 	for _, h := range r.handlers {
-		c, err := h.GetParser().Parse(r.Collector.Collect(pod))
-		if err != nil || c == nil {
-			return admission.Allowed(fmt.Sprintf("can't parse ssarg annotation %v", err))
+		cfg, err := h.GetParser().Parse(annotations)
+		if err != nil {
+			return policy.Respond(fmt.Sprintf("can't parse ssarg annotation %v", err))
+		}
+		if cfg == nil {
+			continue
+		}
+		log.Info("parsed argumentation configuration", "configuration", cfg)
+		before := pod.Spec.DeepCopy()
+		if ch, ok := h.(annotation.ContextPodHandler); ok {
+			err = ch.MutatePodContext(c, &pod.Spec, info, cfg)
+		} else if ph, ok := h.(annotation.PodHandler); ok {
+			err = ph.MutatePod(&pod.Spec, info, cfg)
+		} else {
+			err = h.Mutate(&pod.Spec, info.Ordinal, cfg)
 		}
-		log.Info("parsed argumentation configuration", "configuration", c)
-		err = h.Mutate(&pod.Spec, ordinal, c)
 		if err != nil {
-			return admission.Allowed(fmt.Sprintf("failed to mutate the pod %v", err))
+			return policy.Respond(fmt.Sprintf("failed to mutate the pod %v", err))
+		}
+		if !equality.Semantic.DeepEqual(before, &pod.Spec) {
+			summary.Handlers = append(summary.Handlers, newAppliedHandler(handlerName(h), info.Ordinal, annotations, cfg))
 		}
 	}
+	if r.Policies != nil {
+		ps, err := r.Policies.Policies(c, namespace, ss)
+		if err != nil {
+			return policy.Respond(fmt.Sprintf("failed to look up policies %v", err))
+		}
+		for _, p := range ps {
+			before := pod.Spec.DeepCopy()
+			ordinals, err := policies.Apply(&pod.Spec, info, p)
+			if err != nil {
+				return policy.Respond(fmt.Sprintf("failed to apply policy %s %v", p.Name, err))
+			}
+			if !equality.Semantic.DeepEqual(before, &pod.Spec) {
+				summary.Handlers = append(summary.Handlers, newAppliedPolicy("spoditorpolicy", p.Name, p.Spec, ordinals))
+			}
+		}
+		cps, err := r.Policies.ClusterPolicies(c, namespace, ss)
+		if err != nil {
+			return policy.Respond(fmt.Sprintf("failed to look up cluster policies %v", err))
+		}
+		for _, p := range cps {
+			if skipsClusterPolicy(annotations, p.Name) {
+				log.Info("statefulset opts out of cluster policy", "policy", p.Name)
+				continue
+			}
+			before := pod.Spec.DeepCopy()
+			ordinals, err := policies.ApplyDefaults(&pod.Spec, info, p)
+			if err != nil {
+				return policy.Respond(fmt.Sprintf("failed to apply cluster policy %s %v", p.Name, err))
+			}
+			if !equality.Semantic.DeepEqual(before, &pod.Spec) {
+				summary.Handlers = append(summary.Handlers, newAppliedPolicy("clusterspoditorpolicy", p.Name, p.Spec, ordinals))
+			}
+		}
+	}
+	if r.Guardrails != nil {
+		if violations := r.Guardrails.Check(namespace, &original.Spec, &pod.Spec); len(violations) > 0 {
+			log.Info("mutation blocked by guardrails", "violations", violations)
+			return policy.Respond(fmt.Sprintf("mutation blocked by guardrails, it introduces %s", strings.Join(violations, ", ")))
+		}
+	}
+	if len(summary.Handlers) > 0 {
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[r.key(Applied)] = summary.String()
+	}
 
 	marshaledPod, err := json.Marshal(pod)
 	if err != nil {
-		return admission.Allowed(fmt.Sprintf("failed to marshal the mutated pod %v", err))
+		return policy.Respond(fmt.Sprintf("failed to marshal the mutated pod %v", err))
+	}
+	if dryRun {
+		resp, err := r.dryRunResponse(request, original, marshaledPod)
+		if err != nil {
+			return policy.Respond(fmt.Sprintf("failed to report dry-run patch %v", err))
+		}
+		return resp.WithWarnings(warnings...)
+	}
+	return admission.PatchResponseFromRaw(request.Object.Raw, marshaledPod).WithWarnings(warnings...)
+}
+
+// key of the named annotation spoditor writes
+func (r *PodArgumentor) key(name string) string {
+	return r.syntax().Key(annotation.QualifiedName{Name: name})
+}
+
+// keys of the named annotation spoditor writes, with each prefix of the syntax
+func (r *PodArgumentor) keys(name string) []string {
+	var keys []string
+	for _, p := range r.syntax().Prefixes {
+		keys = append(keys, p+name)
+	}
+	return keys
+}
+
+func (r *PodArgumentor) syntax() annotation.Syntax {
+	if len(r.Syntax.Prefixes) == 0 {
+		return annotation.DefaultSyntax
 	}
-	return admission.PatchResponseFromRaw(request.Object.Raw, marshaledPod)
+	return r.Syntax
 }
 
 func (r *PodArgumentor) InjectDecoder(decoder *admission.Decoder) error {
@@ -74,6 +252,7 @@ func (r *PodArgumentor) SetupWebhookWithManager(mgr ctrl.Manager) {
 		})
 }
 
+// Register appends a handler, handlers being applied in registration order
 func (r *PodArgumentor) Register(h annotation.Handler) {
 	r.handlers = append(r.handlers, h)
 }
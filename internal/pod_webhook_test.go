@@ -0,0 +1,582 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spoditor/spoditor/api/v1alpha1"
+	"github.com/spoditor/spoditor/internal/annotation"
+	"github.com/spoditor/spoditor/internal/annotation/volumes"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+type failingHandler struct{}
+
+func (h *failingHandler) Mutate(*v1.PodSpec, int, interface{}) error {
+	return errors.New("mutation failed")
+}
+
+func (h *failingHandler) GetParser() annotation.Parser {
+	return annotation.ParserFunc(func(map[annotation.QualifiedName]string) (interface{}, error) {
+		return struct{}{}, nil
+	})
+}
+
+func newPodArgumentor(t *testing.T, policy FailurePolicy, handlers ...annotation.Handler) *PodArgumentor {
+	d, err := admission.NewDecoder(runtime.NewScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &PodArgumentor{
+		SSPodId:       LabelSSPodIdentifier,
+		Collector:     annotation.Collector,
+		FailurePolicy: policy,
+	}
+	if err := r.InjectDecoder(d); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range handlers {
+		r.Register(h)
+	}
+	return r
+}
+
+func newPodRequest(t *testing.T, pod *v1.Pod) admission.Request {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+}
+
+func newSSPod(annotations map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web-0",
+			Labels:      map[string]string{"statefulset.kubernetes.io/pod-name": "web-0"},
+			Annotations: annotations,
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "nginx"}},
+		},
+	}
+}
+
+func TestPodArgumentor_Handle_FailurePolicy(t *testing.T) {
+	brokenMount := map[string]string{"spoditor.io/mount-volume": "not json"}
+	tests := []struct {
+		name        string
+		policy      FailurePolicy
+		handlers    []annotation.Handler
+		pod         *v1.Pod
+		wantAllowed bool
+	}{
+		{
+			name:        "non statefulset pod is always allowed",
+			policy:      FailurePolicyDeny,
+			handlers:    []annotation.Handler{&volumes.MountHandler{}},
+			pod:         &v1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: brokenMount}},
+			wantAllowed: true,
+		},
+		{
+			name:        "parse error is allowed by default",
+			handlers:    []annotation.Handler{&volumes.MountHandler{}},
+			pod:         newSSPod(brokenMount),
+			wantAllowed: true,
+		},
+		{
+			name:        "parse error is denied by global policy",
+			policy:      FailurePolicyDeny,
+			handlers:    []annotation.Handler{&volumes.MountHandler{}},
+			pod:         newSSPod(brokenMount),
+			wantAllowed: false,
+		},
+		{
+			name:     "parse error is denied by annotation",
+			policy:   FailurePolicyAllow,
+			handlers: []annotation.Handler{&volumes.MountHandler{}},
+			pod: newSSPod(map[string]string{
+				"spoditor.io/mount-volume": "not json",
+				"spoditor.io/on-error":     "deny",
+			}),
+			wantAllowed: false,
+		},
+		{
+			name:     "annotation overrides global deny",
+			policy:   FailurePolicyDeny,
+			handlers: []annotation.Handler{&volumes.MountHandler{}},
+			pod: newSSPod(map[string]string{
+				"spoditor.io/mount-volume": "not json",
+				"spoditor.io/on-error":     "allow",
+			}),
+			wantAllowed: true,
+		},
+		{
+			name:        "invalid on-error annotation falls back to global policy",
+			policy:      FailurePolicyDeny,
+			handlers:    []annotation.Handler{&volumes.MountHandler{}},
+			pod:         newSSPod(map[string]string{"spoditor.io/on-error": "maybe"}),
+			wantAllowed: false,
+		},
+		{
+			name:        "mutation error is denied",
+			policy:      FailurePolicyDeny,
+			handlers:    []annotation.Handler{&failingHandler{}},
+			pod:         newSSPod(nil),
+			wantAllowed: false,
+		},
+		{
+			name:        "absent annotation is not a failure",
+			policy:      FailurePolicyDeny,
+			handlers:    []annotation.Handler{&volumes.MountHandler{}},
+			pod:         newSSPod(nil),
+			wantAllowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newPodArgumentor(t, tt.policy, tt.handlers...)
+			got := r.Handle(context.TODO(), newPodRequest(t, tt.pod))
+			if got.Allowed != tt.wantAllowed {
+				t.Errorf("Handle() allowed = %v, want %v, result %v", got.Allowed, tt.wantAllowed, got.Result)
+			}
+		})
+	}
+}
+
+func TestParseFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    FailurePolicy
+		wantErr bool
+	}{
+		{name: "allow", s: "allow", want: FailurePolicyAllow},
+		{name: "deny", s: "deny", want: FailurePolicyDeny},
+		{name: "unknown", s: "ignore", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFailurePolicy(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseFailurePolicy() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseFailurePolicy() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodArgumentor_Handle_DryRun(t *testing.T) {
+	mount := `{"volumes":[{"name":"my-volume","secret":{"secretName":"my-secret"}}],"containers":[{"name":"nginx","volumeMounts":[{"name":"my-volume","mountPath":"/etc/secrets"}]}]}`
+	tests := []struct {
+		name          string
+		dryRun        bool
+		requestDryRun bool
+		pod           *v1.Pod
+		wantDryRun    bool
+		wantEvent     bool
+	}{
+		{
+			name:       "mutations are applied by default",
+			pod:        newSSPod(map[string]string{"spoditor.io/mount-volume": mount}),
+			wantDryRun: false,
+		},
+		{
+			name:       "global dry-run",
+			dryRun:     true,
+			pod:        newSSPod(map[string]string{"spoditor.io/mount-volume": mount}),
+			wantDryRun: true,
+			wantEvent:  true,
+		},
+		{
+			name:          "no event for a dry-run request",
+			dryRun:        true,
+			requestDryRun: true,
+			pod:           newSSPod(map[string]string{"spoditor.io/mount-volume": mount}),
+			wantDryRun:    true,
+		},
+		{
+			name: "dry-run annotation",
+			pod: newSSPod(map[string]string{
+				"spoditor.io/mount-volume": mount,
+				"spoditor.io/dry-run":      "true",
+			}),
+			wantDryRun: true,
+			wantEvent:  true,
+		},
+		{
+			name:   "annotation disables global dry-run",
+			dryRun: true,
+			pod: newSSPod(map[string]string{
+				"spoditor.io/mount-volume": mount,
+				"spoditor.io/dry-run":      "false",
+			}),
+			wantDryRun: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newPodArgumentor(t, FailurePolicyAllow, &volumes.MountHandler{})
+			r.DryRun = tt.dryRun
+			recorder := record.NewFakeRecorder(1)
+			r.Recorder = recorder
+			request := newPodRequest(t, tt.pod)
+			request.DryRun = &tt.requestDryRun
+			got := r.Handle(context.TODO(), request)
+			if !got.Allowed {
+				t.Fatalf("Handle() not allowed, result %v", got.Result)
+			}
+			var annotated, mutated bool
+			for _, p := range got.Patches {
+				if p.Path == "/metadata/annotations/spoditor.io~1dry-run-patch" {
+					annotated = true
+				}
+				if p.Path == "/spec/volumes" || p.Path == "/spec/containers/0/volumeMounts" {
+					mutated = true
+				}
+			}
+			if annotated != tt.wantDryRun || mutated == tt.wantDryRun {
+				t.Errorf("Handle() patches = %v, want dry-run %v", got.Patches, tt.wantDryRun)
+			}
+			if (len(got.Warnings) > 0) != tt.wantDryRun {
+				t.Errorf("Handle() warnings = %v, want dry-run %v", got.Warnings, tt.wantDryRun)
+			}
+			if (len(recorder.Events) > 0) != tt.wantEvent {
+				t.Errorf("Handle() events = %d, want event %v", len(recorder.Events), tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestPodArgumentor_Handle_Applied(t *testing.T) {
+	mount := `{"volumes":[{"name":"my-volume","secret":{"secretName":"my-secret"}}],"containers":[{"name":"nginx","volumeMounts":[{"name":"my-volume","mountPath":"/etc/secrets"}]}]}`
+	tests := []struct {
+		name        string
+		pod         *v1.Pod
+		operation   admissionv1.Operation
+		wantApplied bool
+		wantPatched bool
+		wantWarning bool
+	}{
+		{
+			name:        "record applied mutation",
+			pod:         newSSPod(map[string]string{"spoditor.io/mount-volume": mount}),
+			wantApplied: true,
+			wantPatched: true,
+		},
+		{
+			name: "record the only annotation applied",
+			pod: newSSPod(map[string]string{
+				"spoditor.io/mount-volume":   mount,
+				"spoditor.io/mount-volume_0": mount,
+			}),
+			wantApplied: true,
+			wantPatched: true,
+		},
+		{
+			name:        "nothing applied when qualifier excludes the pod",
+			pod:         newSSPod(map[string]string{"spoditor.io/mount-volume_1-": mount}),
+			wantApplied: false,
+			wantPatched: false,
+		},
+		{
+			name: "skip already mutated pod",
+			pod: newSSPod(map[string]string{
+				"spoditor.io/mount-volume": mount,
+				"spoditor.io/applied":      "{}",
+			}),
+			operation:   admissionv1.Update,
+			wantApplied: false,
+			wantPatched: false,
+		},
+		{
+			name: "mutate new pod whose template carries the applied annotation",
+			pod: newSSPod(map[string]string{
+				"spoditor.io/mount-volume": mount,
+				"spoditor.io/applied":      "{}",
+			}),
+			wantApplied: true,
+			wantPatched: true,
+			wantWarning: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newPodArgumentor(t, FailurePolicyAllow, &volumes.MountHandler{})
+			request := newPodRequest(t, tt.pod)
+			if tt.operation != "" {
+				request.Operation = tt.operation
+			}
+			got := r.Handle(context.TODO(), request)
+			if !got.Allowed {
+				t.Fatalf("Handle() not allowed, result %v", got.Result)
+			}
+			if (len(got.Patches) > 0) != tt.wantPatched {
+				t.Errorf("Handle() patches = %v, want patched %v", got.Patches, tt.wantPatched)
+			}
+			if (len(got.Warnings) > 0) != tt.wantWarning {
+				t.Errorf("Handle() warnings = %v, want warning %v", got.Warnings, tt.wantWarning)
+			}
+			var summary *AppliedSummary
+			for _, p := range got.Patches {
+				if p.Path == "/metadata/annotations/spoditor.io~1applied" {
+					summary = &AppliedSummary{}
+					if err := json.Unmarshal([]byte(p.Value.(string)), summary); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if (summary != nil) != tt.wantApplied {
+				t.Fatalf("Handle() applied = %v, want %v", summary, tt.wantApplied)
+			}
+			if summary == nil {
+				return
+			}
+			if summary.Version != Version || len(summary.Handlers) != 1 || summary.Handlers[0].Name != volumes.MountVolume ||
+				len(summary.Handlers[0].Qualifiers) != 1 {
+				t.Fatalf("Handle() applied = %v, want a single %s annotation", summary, volumes.MountVolume)
+			}
+			annotations := annotation.Collector.Collect(tt.pod)
+			q := summary.Handlers[0].Qualifiers[0]
+			want := newAppliedHandler(volumes.MountVolume, 0, map[annotation.QualifiedName]string{
+				{Name: volumes.MountVolume, Qualifier: q}: annotations[annotation.QualifiedName{Name: volumes.MountVolume, Qualifier: q}],
+			}, nil)
+			if summary.Handlers[0].ConfigHash != want.ConfigHash {
+				t.Errorf("Handle() applied = %v, want handler %v", summary, want)
+			}
+		})
+	}
+}
+
+func TestPodArgumentor_Handle_QualifierOrdinals(t *testing.T) {
+	mount := `{"volumes":[{"name":"my-volume","secret":{"secretName":"my-secret-{{.AbsoluteOrdinal}}"}}],"containers":[{"name":"nginx","volumeMounts":[{"name":"my-volume","mountPath":"/etc/secrets"}]}]}`
+	logicalMount := strings.Replace(mount, "AbsoluteOrdinal", "LogicalOrdinal", 1)
+	pod := func(apiVersion, kind string, annotations map[string]string) *v1.Pod {
+		p := newSSPod(annotations)
+		p.Name = "web-5"
+		p.Labels["statefulset.kubernetes.io/pod-name"] = "web-5"
+		controller := true
+		p.OwnerReferences = []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: "web", Controller: &controller}}
+		return p
+	}
+	tests := []struct {
+		name       string
+		mode       OrdinalMode
+		pod        *v1.Pod
+		wantLookup bool
+		wantSecret string
+	}{
+		{
+			name:       "absolute ordinal by default",
+			pod:        pod("apps/v1", "StatefulSet", map[string]string{"spoditor.io/mount-volume_5": mount}),
+			wantLookup: true,
+			wantSecret: "my-secret-5",
+		},
+		{
+			name:       "logical ordinal template in absolute mode",
+			pod:        pod("apps/v1", "StatefulSet", map[string]string{"spoditor.io/mount-volume_5": logicalMount}),
+			wantLookup: true,
+			wantSecret: "my-secret-0",
+		},
+		{
+			name:       "logical ordinal excludes absolute qualifier",
+			mode:       OrdinalModeLogical,
+			pod:        pod("apps/v1", "StatefulSet", map[string]string{"spoditor.io/mount-volume_5": mount}),
+			wantLookup: true,
+			wantSecret: "",
+		},
+		{
+			name:       "logical ordinal",
+			mode:       OrdinalModeLogical,
+			pod:        pod("apps/v1", "StatefulSet", map[string]string{"spoditor.io/mount-volume_0": mount}),
+			wantLookup: true,
+			wantSecret: "my-secret-5",
+		},
+		{
+			name: "annotation selects logical ordinal",
+			pod: pod("apps/v1", "StatefulSet", map[string]string{
+				"spoditor.io/mount-volume_0":     mount,
+				"spoditor.io/qualifier-ordinals": "logical",
+			}),
+			wantLookup: true,
+			wantSecret: "my-secret-5",
+		},
+		{
+			name:       "logical ordinal of another controller is absolute",
+			mode:       OrdinalModeLogical,
+			pod:        pod("apps.kruise.io/v1beta1", "StatefulSet", map[string]string{"spoditor.io/mount-volume_5": mount}),
+			wantSecret: "my-secret-5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newPodArgumentor(t, FailurePolicyDeny, &volumes.MountHandler{})
+			r.SSPodId = ChainSSPodIdentifier(NewOwnerSSPodIdentifier(StatefulSetOwnerRule,
+				OwnerRule{Group: "apps.kruise.io", Kind: "StatefulSet"}))
+			r.QualifierOrdinals = tt.mode
+			lookup := false
+			r.OrdinalsStart = OrdinalsStartResolverFunc(func(_ context.Context, _, name string) (int, error) {
+				if name != "web" {
+					t.Errorf("OrdinalsStart() name = %v, want web", name)
+				}
+				lookup = true
+				return 5, nil
+			})
+			got := r.Handle(context.TODO(), newPodRequest(t, tt.pod))
+			if lookup != tt.wantLookup {
+				t.Errorf("Handle() looked up ordinals start = %v, want %v", lookup, tt.wantLookup)
+			}
+			if !got.Allowed {
+				t.Fatalf("Handle() not allowed, result %v", got.Result)
+			}
+			secret := ""
+			for _, p := range got.Patches {
+				if p.Path == "/spec/volumes" {
+					secret = p.Value.([]interface{})[0].(map[string]interface{})["secret"].(map[string]interface{})["secretName"].(string)
+				}
+			}
+			if secret != tt.wantSecret {
+				t.Errorf("Handle() secret = %v, want %v", secret, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestNewStatefulSetOrdinalsStart(t *testing.T) {
+	ss := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+	start := NewStatefulSetOrdinalsStart(fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(ss).Build())
+	if got, err := start(context.TODO(), "default", "web"); err != nil || got != 0 {
+		t.Errorf("OrdinalsStart() = %v, %v, want 0 without spec.ordinals", got, err)
+	}
+	if _, err := start(context.TODO(), "default", "missing"); err == nil {
+		t.Errorf("OrdinalsStart() of a missing statefulset, want error")
+	}
+}
+
+type stubPolicySource struct {
+	policies        []*v1alpha1.SpoditorPolicy
+	clusterPolicies []*v1alpha1.ClusterSpoditorPolicy
+	err             error
+}
+
+func (s *stubPolicySource) Policies(context.Context, string, string) ([]*v1alpha1.SpoditorPolicy, error) {
+	return s.policies, s.err
+}
+
+func (s *stubPolicySource) ClusterPolicies(context.Context, string, string) ([]*v1alpha1.ClusterSpoditorPolicy, error) {
+	return s.clusterPolicies, s.err
+}
+
+func TestPodArgumentor_Handle_Policies(t *testing.T) {
+	rules := []v1alpha1.PolicyRule{
+		{
+			Ordinals:   "0",
+			Containers: []v1alpha1.ContainerRule{{Name: "nginx", Env: []v1.EnvVar{{Name: "ROLE", Value: "primary"}}}},
+		},
+	}
+	policy := &v1alpha1.SpoditorPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "primary"},
+		Spec: v1alpha1.SpoditorPolicySpec{
+			Target: v1alpha1.PolicyTarget{Name: "web"},
+			Rules:  rules,
+		},
+	}
+	defaultRole := &v1alpha1.ClusterSpoditorPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default-role"},
+		Spec: v1alpha1.ClusterSpoditorPolicySpec{
+			Selector: &metav1.LabelSelector{},
+			Rules: []v1alpha1.PolicyRule{
+				{Containers: []v1alpha1.ContainerRule{{Name: "nginx", Env: []v1.EnvVar{{Name: "ROLE", Value: "default"}}}}},
+			},
+		},
+	}
+	tests := []struct {
+		name        string
+		source      *stubPolicySource
+		annotations map[string]string
+		wantAllowed bool
+		wantRole    string
+	}{
+		{
+			name:        "no policy",
+			source:      &stubPolicySource{},
+			wantAllowed: true,
+		},
+		{
+			name:        "policy applied",
+			source:      &stubPolicySource{policies: []*v1alpha1.SpoditorPolicy{policy}},
+			wantAllowed: true,
+			wantRole:    "primary",
+		},
+		{
+			name:        "cluster policy applied",
+			source:      &stubPolicySource{clusterPolicies: []*v1alpha1.ClusterSpoditorPolicy{defaultRole}},
+			wantAllowed: true,
+			wantRole:    "default",
+		},
+		{
+			name: "policy takes precedence over cluster policy",
+			source: &stubPolicySource{
+				policies:        []*v1alpha1.SpoditorPolicy{policy},
+				clusterPolicies: []*v1alpha1.ClusterSpoditorPolicy{defaultRole},
+			},
+			wantAllowed: true,
+			wantRole:    "primary",
+		},
+		{
+			name:        "statefulset opts out of cluster policy",
+			source:      &stubPolicySource{clusterPolicies: []*v1alpha1.ClusterSpoditorPolicy{defaultRole}},
+			annotations: map[string]string{"spoditor.io/skip-cluster-policies": "other, default-role"},
+			wantAllowed: true,
+		},
+		{
+			name:        "statefulset opts out of all cluster policies",
+			source:      &stubPolicySource{clusterPolicies: []*v1alpha1.ClusterSpoditorPolicy{defaultRole}},
+			annotations: map[string]string{"spoditor.io/skip-cluster-policies": "*"},
+			wantAllowed: true,
+		},
+		{
+			name:        "policy source error follows failure policy",
+			source:      &stubPolicySource{err: errors.New("unavailable")},
+			wantAllowed: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newPodArgumentor(t, FailurePolicyDeny)
+			r.Policies = tt.source
+			got := r.Handle(context.TODO(), newPodRequest(t, newSSPod(tt.annotations)))
+			if got.Allowed != tt.wantAllowed {
+				t.Fatalf("Handle() allowed = %v, want %v, result %v", got.Allowed, tt.wantAllowed, got.Result)
+			}
+			role := ""
+			for _, p := range got.Patches {
+				if p.Path == "/spec/containers/0/env" {
+					role = p.Value.([]interface{})[0].(map[string]interface{})["value"].(string)
+				}
+			}
+			if role != tt.wantRole {
+				t.Errorf("Handle() role = %v, want %v, patches %v", role, tt.wantRole, got.Patches)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package policies
+
+import (
+	"fmt"
+
+	"github.com/spoditor/spoditor/api/v1alpha1"
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Apply mutates the pod spec with the rules of the policy qualifying the pod ordinal,
+// returning the ordinals of the applied rules
+func Apply(spec *corev1.PodSpec, pod *annotation.PodInfo, p *v1alpha1.SpoditorPolicy) ([]string, error) {
+	return apply(spec, pod, p.Name, p.Spec.Rules, false)
+}
+
+// ApplyDefaults mutates the pod spec with the rules of the cluster policy qualifying the pod ordinal, returning the
+// ordinals of the applied rules. Unlike Apply, it never replaces a volume, container, volume mount path, env variable
+// or resource already in the pod, and skips the containers the pod doesn't have
+func ApplyDefaults(spec *corev1.PodSpec, pod *annotation.PodInfo, p *v1alpha1.ClusterSpoditorPolicy) ([]string, error) {
+	return apply(spec, pod, p.Name, p.Spec.Rules, true)
+}
+
+func apply(spec *corev1.PodSpec, pod *annotation.PodInfo, name string, rules []v1alpha1.PolicyRule, defaults bool) ([]string, error) {
+	ll := log.WithValues("policy", name, "ordinal", pod.Ordinal)
+	var applied []string
+	for _, rule := range rules {
+		r, err := annotation.ParseQualifier(rule.Ordinals)
+		if err != nil {
+			return nil, err
+		}
+		if !r.Contains(pod.Ordinal) {
+			ll.Info("rule excludes this pod", "ordinals", rule.Ordinals)
+			continue
+		}
+		ll.Info("apply rule", "ordinals", rule.Ordinals)
+		applied = append(applied, rule.Ordinals)
+		rule := rule.DeepCopy()
+		for i := range rule.Volumes {
+			if err := expandVolume(&rule.Volumes[i], pod); err != nil {
+				return nil, err
+			}
+			if defaults && hasVolume(spec, rule.Volumes[i].Name) {
+				ll.Info("keep existing volume", "volume", rule.Volumes[i].Name)
+				continue
+			}
+			if hasVolume(spec, rule.Volumes[i].Name) {
+				return nil, fmt.Errorf("volume %s of policy %s conflicts with a volume of the pod", rule.Volumes[i].Name, name)
+			}
+			spec.Volumes = append(spec.Volumes, rule.Volumes[i])
+		}
+		for _, c := range rule.Sidecars {
+			if containerIndex(spec, c.Name) >= 0 {
+				if defaults {
+					ll.Info("keep existing container", "container", c.Name)
+					continue
+				}
+				return nil, fmt.Errorf("sidecar %s of policy %s conflicts with a container of the pod", c.Name, name)
+			}
+			spec.Containers = append(spec.Containers, c)
+		}
+		for _, c := range rule.Containers {
+			i := containerIndex(spec, c.Name)
+			if i < 0 {
+				if defaults {
+					ll.Info("skip container not found in pod", "container", c.Name)
+					continue
+				}
+				return nil, fmt.Errorf("container %s of policy %s not found in pod", c.Name, name)
+			}
+			if err := applyContainer(&spec.Containers[i], &c, defaults); err != nil {
+				return nil, fmt.Errorf("%v of policy %s", err, name)
+			}
+		}
+	}
+	return applied, nil
+}
+
+func hasVolume(spec *corev1.PodSpec, name string) bool {
+	for _, v := range spec.Volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containerIndex(spec *corev1.PodSpec, name string) int {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func expandVolume(v *corev1.Volume, pod *annotation.PodInfo) error {
+	var err error
+	if v.ConfigMap != nil && annotation.IsTemplate(v.ConfigMap.Name) {
+		if v.ConfigMap.Name, err = pod.Expand(v.ConfigMap.Name); err != nil {
+			return err
+		}
+	}
+	if v.Secret != nil && annotation.IsTemplate(v.Secret.SecretName) {
+		if v.Secret.SecretName, err = pod.Expand(v.Secret.SecretName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyContainer patches the container with the rule, keeping what the container already has in defaults mode
+func applyContainer(c *corev1.Container, rule *v1alpha1.ContainerRule, defaults bool) error {
+	for _, m := range rule.VolumeMounts {
+		if hasMountPath(c, m.MountPath) {
+			if defaults {
+				continue
+			}
+			return fmt.Errorf("volume mount %s of container %s conflicts with a mount of the container", m.MountPath, c.Name)
+		}
+		c.VolumeMounts = append(c.VolumeMounts, m)
+	}
+	for _, e := range rule.Env {
+		replaced := false
+		for i := range c.Env {
+			if c.Env[i].Name == e.Name {
+				if !defaults {
+					c.Env[i] = e
+				}
+				replaced = true
+			}
+		}
+		if !replaced {
+			c.Env = append(c.Env, e)
+		}
+	}
+	if rule.Resources != nil {
+		c.Resources.Requests = mergeResources(c.Resources.Requests, rule.Resources.Requests, defaults)
+		c.Resources.Limits = mergeResources(c.Resources.Limits, rule.Resources.Limits, defaults)
+	}
+	return nil
+}
+
+func hasMountPath(c *corev1.Container, path string) bool {
+	for _, m := range c.VolumeMounts {
+		if m.MountPath == path {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeResources(dst, src corev1.ResourceList, defaults bool) corev1.ResourceList {
+	if len(src) > 0 && dst == nil {
+		dst = corev1.ResourceList{}
+	}
+	for k, v := range src {
+		if _, ok := dst[k]; ok && defaults {
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
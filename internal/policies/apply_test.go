@@ -0,0 +1,238 @@
+package policies
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spoditor/spoditor/api/v1alpha1"
+	"github.com/spoditor/spoditor/internal/annotation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApply(t *testing.T) {
+	policy := newPolicy("default", "web", v1alpha1.PolicyTarget{Name: "web"})
+	policy.Spec.Rules = []v1alpha1.PolicyRule{
+		{
+			Ordinals: "0",
+			Containers: []v1alpha1.ContainerRule{
+				{
+					Name: "nginx",
+					Env:  []corev1.EnvVar{{Name: "ROLE", Value: "primary"}},
+					Resources: &corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+					},
+				},
+			},
+		},
+		{
+			Ordinals: "1-",
+			Volumes: []corev1.Volume{
+				{
+					Name: "config",
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "{{.StatefulSet}}-{{.Ordinal}}"},
+						},
+					},
+				},
+			},
+			Containers: []v1alpha1.ContainerRule{
+				{
+					Name:         "nginx",
+					VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: "/etc/config"}},
+				},
+			},
+		},
+	}
+	newSpec := func() *corev1.PodSpec {
+		return &corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "nginx", Env: []corev1.EnvVar{{Name: "ROLE", Value: "replica"}}},
+			},
+		}
+	}
+	tests := []struct {
+		name    string
+		ordinal int
+		want    *corev1.PodSpec
+		wantOrd []string
+	}{
+		{
+			name:    "primary",
+			ordinal: 0,
+			want: &corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name: "nginx",
+						Env:  []corev1.EnvVar{{Name: "ROLE", Value: "primary"}},
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+						},
+					},
+				},
+			},
+			wantOrd: []string{"0"},
+		},
+		{
+			name:    "replica",
+			ordinal: 2,
+			want: &corev1.PodSpec{
+				Volumes: []corev1.Volume{
+					{
+						Name: "config",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "web-2"},
+							},
+						},
+					},
+				},
+				Containers: []corev1.Container{
+					{
+						Name:         "nginx",
+						Env:          []corev1.EnvVar{{Name: "ROLE", Value: "replica"}},
+						VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: "/etc/config"}},
+					},
+				},
+			},
+			wantOrd: []string{"1-"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := annotation.NewPodInfo(tt.ordinal)
+			pod.StatefulSet = "web"
+			spec := newSpec()
+			got, err := Apply(spec, pod, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.wantOrd) {
+				t.Errorf("Apply() ordinals = %v, want %v", got, tt.wantOrd)
+			}
+			if !reflect.DeepEqual(spec, tt.want) {
+				t.Errorf("Apply() spec = %v, want %v", spec, tt.want)
+			}
+		})
+	}
+	if policy.Spec.Rules[1].Volumes[0].ConfigMap.Name != "{{.StatefulSet}}-{{.Ordinal}}" {
+		t.Errorf("Apply() modified the policy")
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	policy := &v1alpha1.ClusterSpoditorPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup"},
+		Spec: v1alpha1.ClusterSpoditorPolicySpec{
+			Selector: &metav1.LabelSelector{},
+			Rules: []v1alpha1.PolicyRule{
+				{
+					Ordinals: "2",
+					Volumes:  []corev1.Volume{{Name: "data"}, {Name: "backup"}},
+					Sidecars: []corev1.Container{{Name: "nginx"}, {Name: "backup"}},
+					Containers: []v1alpha1.ContainerRule{
+						{
+							Name: "nginx",
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "backup", MountPath: "/data"},
+								{Name: "backup", MountPath: "/backup"},
+							},
+							Env: []corev1.EnvVar{{Name: "ROLE", Value: "backup"}, {Name: "BACKUP", Value: "true"}},
+							Resources: &corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceMemory: resource.MustParse("1Gi"),
+									corev1.ResourceCPU:    resource.MustParse("1"),
+								},
+							},
+						},
+						{Name: "missing"},
+					},
+				},
+			},
+		},
+	}
+	spec := &corev1.PodSpec{
+		Volumes: []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+		Containers: []corev1.Container{
+			{
+				Name:         "nginx",
+				Image:        "nginx",
+				VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}},
+				Env:          []corev1.EnvVar{{Name: "ROLE", Value: "primary"}},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+				},
+			},
+		},
+	}
+	want := &corev1.PodSpec{
+		Volumes: []corev1.Volume{
+			{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			{Name: "backup"},
+		},
+		Containers: []corev1.Container{
+			{
+				Name:  "nginx",
+				Image: "nginx",
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "data", MountPath: "/data"},
+					{Name: "backup", MountPath: "/backup"},
+				},
+				Env: []corev1.EnvVar{{Name: "ROLE", Value: "primary"}, {Name: "BACKUP", Value: "true"}},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse("2Gi"),
+						corev1.ResourceCPU:    resource.MustParse("1"),
+					},
+				},
+			},
+			{Name: "backup"},
+		},
+	}
+	pod := annotation.NewPodInfo(2)
+	got, err := ApplyDefaults(spec, pod, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"2"}) {
+		t.Errorf("ApplyDefaults() ordinals = %v, want [2]", got)
+	}
+	if !reflect.DeepEqual(spec, want) {
+		t.Errorf("ApplyDefaults() spec = %v, want %v", spec, want)
+	}
+}
+
+func TestApply_SidecarConflict(t *testing.T) {
+	policy := newPolicy("default", "web", v1alpha1.PolicyTarget{Name: "web"})
+	policy.Spec.Rules = []v1alpha1.PolicyRule{{Sidecars: []corev1.Container{{Name: "nginx"}}}}
+	spec := &corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx"}}}
+	if _, err := Apply(spec, annotation.NewPodInfo(0), policy); err == nil {
+		t.Errorf("Apply() expected error for sidecar conflicting with a container")
+	}
+}
+
+func TestApply_VolumeConflict(t *testing.T) {
+	policy := newPolicy("default", "web", v1alpha1.PolicyTarget{Name: "web"})
+	policy.Spec.Rules = []v1alpha1.PolicyRule{{Volumes: []corev1.Volume{{Name: "data"}}}}
+	spec := &corev1.PodSpec{Volumes: []corev1.Volume{{Name: "data"}}, Containers: []corev1.Container{{Name: "nginx"}}}
+	if _, err := Apply(spec, annotation.NewPodInfo(0), policy); err == nil {
+		t.Errorf("Apply() expected error for volume conflicting with a volume of the pod")
+	}
+}
+
+func TestApply_MountPathConflict(t *testing.T) {
+	policy := newPolicy("default", "web", v1alpha1.PolicyTarget{Name: "web"})
+	policy.Spec.Rules = []v1alpha1.PolicyRule{{Containers: []v1alpha1.ContainerRule{{
+		Name:         "nginx",
+		VolumeMounts: []corev1.VolumeMount{{Name: "replica", MountPath: "/etc/config"}},
+	}}}}
+	spec := &corev1.PodSpec{Containers: []corev1.Container{{
+		Name:         "nginx",
+		VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: "/etc/config"}},
+	}}}
+	if _, err := Apply(spec, annotation.NewPodInfo(0), policy); err == nil {
+		t.Errorf("Apply() expected error for volume mount conflicting with a mount of the container")
+	}
+}
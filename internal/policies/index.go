@@ -0,0 +1,315 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spoditor/spoditor/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var log = logf.Log.WithName("policies")
+
+// +kubebuilder:rbac:groups=spoditor.io,resources=spoditorpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=spoditor.io,resources=clusterspoditorpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+
+// Index keeps the SpoditorPolicies and ClusterSpoditorPolicies of the cluster in memory for the webhook to look up
+type Index struct {
+	mu              sync.RWMutex
+	policies        map[types.NamespacedName]*v1alpha1.SpoditorPolicy
+	clusterPolicies map[string]*v1alpha1.ClusterSpoditorPolicy
+	// StatefulSets reads the labels of the StatefulSets when policies target them by selector
+	StatefulSets client.Reader
+	// Namespaces reads the labels of the namespaces when cluster policies select them
+	Namespaces client.Reader
+	// synced tells whether the objects of the informers feeding the index have been delivered to the index
+	synced []func() bool
+}
+
+// NewIndex creates an empty index reading StatefulSets and namespaces with the reader
+func NewIndex(reader client.Reader) *Index {
+	return &Index{
+		policies:        map[types.NamespacedName]*v1alpha1.SpoditorPolicy{},
+		clusterPolicies: map[string]*v1alpha1.ClusterSpoditorPolicy{},
+		StatefulSets:    reader,
+		Namespaces:      reader,
+	}
+}
+
+func (i *Index) Set(p *v1alpha1.SpoditorPolicy) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	log.Info("index policy", "namespace", p.Namespace, "name", p.Name)
+	i.policies[types.NamespacedName{Namespace: p.Namespace, Name: p.Name}] = p.DeepCopy()
+}
+
+func (i *Index) Delete(key types.NamespacedName) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	log.Info("remove policy from index", "namespace", key.Namespace, "name", key.Name)
+	delete(i.policies, key)
+}
+
+func (i *Index) SetCluster(p *v1alpha1.ClusterSpoditorPolicy) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	log.Info("index cluster policy", "name", p.Name)
+	i.clusterPolicies[p.Name] = p.DeepCopy()
+}
+
+func (i *Index) DeleteCluster(name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	log.Info("remove cluster policy from index", "name", name)
+	delete(i.clusterPolicies, name)
+}
+
+// Policies returns the policies of the namespace targeting the StatefulSet, ordered by name
+func (i *Index) Policies(ctx context.Context, namespace, statefulSet string) ([]*v1alpha1.SpoditorPolicy, error) {
+	i.mu.RLock()
+	var candidates []*v1alpha1.SpoditorPolicy
+	for k, p := range i.policies {
+		if k.Namespace == namespace {
+			candidates = append(candidates, p)
+		}
+	}
+	i.mu.RUnlock()
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].Name < candidates[b].Name
+	})
+
+	var ssLabels labels.Set
+	var matched []*v1alpha1.SpoditorPolicy
+	for _, p := range candidates {
+		t := p.Spec.Target
+		if t.Name == "" && t.Selector == nil {
+			continue
+		}
+		if t.Name != "" && t.Name != statefulSet {
+			continue
+		}
+		if t.Selector != nil {
+			s, err := metav1.LabelSelectorAsSelector(t.Selector)
+			if err != nil {
+				log.Error(err, "skip policy with invalid selector", "namespace", p.Namespace, "name", p.Name)
+				continue
+			}
+			if ssLabels == nil {
+				if ssLabels, err = i.statefulSetLabels(ctx, namespace, statefulSet); err != nil {
+					return nil, err
+				}
+			}
+			if !s.Matches(ssLabels) {
+				continue
+			}
+		}
+		matched = append(matched, p)
+	}
+	return matched, nil
+}
+
+// ClusterPolicies returns the cluster policies selecting the StatefulSet and its namespace, ordered by name
+func (i *Index) ClusterPolicies(ctx context.Context, namespace, statefulSet string) ([]*v1alpha1.ClusterSpoditorPolicy, error) {
+	i.mu.RLock()
+	var candidates []*v1alpha1.ClusterSpoditorPolicy
+	for _, p := range i.clusterPolicies {
+		if p.Spec.Selector != nil {
+			candidates = append(candidates, p)
+		}
+	}
+	i.mu.RUnlock()
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].Name < candidates[b].Name
+	})
+
+	var ssLabels, nsLabels labels.Set
+	var matched []*v1alpha1.ClusterSpoditorPolicy
+	for _, p := range candidates {
+		s, err := metav1.LabelSelectorAsSelector(p.Spec.Selector)
+		if err != nil {
+			log.Error(err, "skip cluster policy with invalid selector", "name", p.Name)
+			continue
+		}
+		if ssLabels == nil {
+			if ssLabels, err = i.statefulSetLabels(ctx, namespace, statefulSet); err != nil {
+				return nil, err
+			}
+		}
+		if !s.Matches(ssLabels) {
+			continue
+		}
+		if p.Spec.NamespaceSelector != nil {
+			ns, err := metav1.LabelSelectorAsSelector(p.Spec.NamespaceSelector)
+			if err != nil {
+				log.Error(err, "skip cluster policy with invalid namespace selector", "name", p.Name)
+				continue
+			}
+			if nsLabels == nil {
+				if nsLabels, err = i.namespaceLabels(ctx, namespace); err != nil {
+					return nil, err
+				}
+			}
+			if !ns.Matches(nsLabels) {
+				continue
+			}
+		}
+		matched = append(matched, p)
+	}
+	return matched, nil
+}
+
+func (i *Index) namespaceLabels(ctx context.Context, name string) (labels.Set, error) {
+	ns := &corev1.Namespace{}
+	if err := i.Namespaces.Get(ctx, client.ObjectKey{Name: name}, ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return labels.Set{}, nil
+		}
+		return nil, err
+	}
+	return labels.Set(ns.Labels), nil
+}
+
+func (i *Index) statefulSetLabels(ctx context.Context, namespace, name string) (labels.Set, error) {
+	ss := &appsv1.StatefulSet{}
+	if err := i.StatefulSets.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, ss); err != nil {
+		if apierrors.IsNotFound(err) {
+			return labels.Set{}, nil
+		}
+		return nil, err
+	}
+	return labels.Set(ss.Labels), nil
+}
+
+// SetupWithManager keeps the index up to date with the informers of the manager cache.
+// Unlike a controller, it runs on every replica serving the webhook, not only on the leader.
+func (i *Index) SetupWithManager(mgr ctrl.Manager) error {
+	informer, err := mgr.GetCache().GetInformer(context.TODO(), &v1alpha1.SpoditorPolicy{})
+	if err != nil {
+		return err
+	}
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if p, ok := obj.(*v1alpha1.SpoditorPolicy); ok {
+				i.Set(p)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if p, ok := obj.(*v1alpha1.SpoditorPolicy); ok {
+				i.Set(p)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if t, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = t.Obj
+			}
+			if p, ok := obj.(*v1alpha1.SpoditorPolicy); ok {
+				i.Delete(types.NamespacedName{Namespace: p.Namespace, Name: p.Name})
+			}
+		},
+	})
+	clusterInformer, err := mgr.GetCache().GetInformer(context.TODO(), &v1alpha1.ClusterSpoditorPolicy{})
+	if err != nil {
+		return err
+	}
+	i.synced = []func() bool{
+		delivered(informer.HasSynced, func() bool { return i.policiesDelivered(mgr.GetCache()) }),
+		delivered(clusterInformer.HasSynced, func() bool { return i.clusterPoliciesDelivered(mgr.GetCache()) }),
+	}
+	clusterInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if p, ok := obj.(*v1alpha1.ClusterSpoditorPolicy); ok {
+				i.SetCluster(p)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if p, ok := obj.(*v1alpha1.ClusterSpoditorPolicy); ok {
+				i.SetCluster(p)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if t, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = t.Obj
+			}
+			if p, ok := obj.(*v1alpha1.ClusterSpoditorPolicy); ok {
+				i.DeleteCluster(p.Name)
+			}
+		},
+	})
+	return nil
+}
+
+// delivered returns a check passing once the informer has synced and its initial objects have reached the index.
+// The informer syncs its store before its handlers receive the objects, so its HasSynced alone is not enough
+func delivered(hasSynced func() bool, indexed func() bool) func() bool {
+	var done int32
+	return func() bool {
+		if atomic.LoadInt32(&done) == 1 {
+			return true
+		}
+		if !hasSynced() || !indexed() {
+			return false
+		}
+		atomic.StoreInt32(&done, 1)
+		return true
+	}
+}
+
+// policiesDelivered tells whether the index has every policy of the cache
+func (i *Index) policiesDelivered(cache client.Reader) bool {
+	list := &v1alpha1.SpoditorPolicyList{}
+	if err := cache.List(context.TODO(), list); err != nil {
+		log.Error(err, "unable to list policies")
+		return false
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, p := range list.Items {
+		if _, ok := i.policies[types.NamespacedName{Namespace: p.Namespace, Name: p.Name}]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// clusterPoliciesDelivered tells whether the index has every cluster policy of the cache
+func (i *Index) clusterPoliciesDelivered(cache client.Reader) bool {
+	list := &v1alpha1.ClusterSpoditorPolicyList{}
+	if err := cache.List(context.TODO(), list); err != nil {
+		log.Error(err, "unable to list cluster policies")
+		return false
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, p := range list.Items {
+		if _, ok := i.clusterPolicies[p.Name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Synced is a readyz check failing until the policies are indexed, so that no pod is admitted without its policies
+// while the informers of the manager cache are syncing or delivering their objects
+func (i *Index) Synced(_ *http.Request) error {
+	for _, synced := range i.synced {
+		if !synced() {
+			return errors.New("policies not synced yet")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package policies
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/spoditor/spoditor/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newPolicy(namespace, name string, target v1alpha1.PolicyTarget) *v1alpha1.SpoditorPolicy {
+	return &v1alpha1.SpoditorPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       v1alpha1.SpoditorPolicySpec{Target: target},
+	}
+}
+
+func TestIndex_Policies(t *testing.T) {
+	statefulSets := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Labels: map[string]string{"tier": "db"}}},
+	).Build()
+	index := NewIndex(statefulSets)
+	index.Set(newPolicy("default", "by-name", v1alpha1.PolicyTarget{Name: "web"}))
+	index.Set(newPolicy("default", "by-selector", v1alpha1.PolicyTarget{
+		Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "db"}},
+	}))
+	index.Set(newPolicy("default", "other-name", v1alpha1.PolicyTarget{Name: "db"}))
+	index.Set(newPolicy("default", "other-selector", v1alpha1.PolicyTarget{
+		Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "web"}},
+	}))
+	index.Set(newPolicy("default", "no-target", v1alpha1.PolicyTarget{}))
+	index.Set(newPolicy("other", "other-namespace", v1alpha1.PolicyTarget{Name: "web"}))
+	index.Set(newPolicy("default", "deleted", v1alpha1.PolicyTarget{Name: "web"}))
+	index.Delete(types.NamespacedName{Namespace: "default", Name: "deleted"})
+
+	tests := []struct {
+		name        string
+		namespace   string
+		statefulSet string
+		want        []string
+	}{
+		{name: "by name and selector", namespace: "default", statefulSet: "web", want: []string{"by-name", "by-selector"}},
+		{name: "unknown statefulset", namespace: "default", statefulSet: "db", want: []string{"other-name"}},
+		{name: "other namespace", namespace: "other", statefulSet: "web", want: []string{"other-namespace"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := index.Policies(context.TODO(), tt.namespace, tt.statefulSet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, p := range got {
+				names = append(names, p.Name)
+			}
+			if len(names) != len(tt.want) {
+				t.Fatalf("Policies() got = %v, want %v", names, tt.want)
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Errorf("Policies() got = %v, want %v", names, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func newClusterPolicy(name string, namespaceSelector, selector *metav1.LabelSelector) *v1alpha1.ClusterSpoditorPolicy {
+	return &v1alpha1.ClusterSpoditorPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1alpha1.ClusterSpoditorPolicySpec{
+			NamespaceSelector: namespaceSelector,
+			Selector:          selector,
+		},
+	}
+}
+
+func TestIndex_ClusterPolicies(t *testing.T) {
+	reader := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"env": "prod"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{"env": "dev"}}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "db", Labels: map[string]string{"backup": "true"}}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "dev", Name: "db", Labels: map[string]string{"backup": "true"}}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web"}},
+	).Build()
+	backup := &metav1.LabelSelector{MatchLabels: map[string]string{"backup": "true"}}
+	index := NewIndex(reader)
+	index.SetCluster(newClusterPolicy("backup", nil, backup))
+	index.SetCluster(newClusterPolicy("prod-backup", &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}, backup))
+	index.SetCluster(newClusterPolicy("all", nil, &metav1.LabelSelector{}))
+	index.SetCluster(newClusterPolicy("no-selector", nil, nil))
+	index.SetCluster(newClusterPolicy("deleted", nil, &metav1.LabelSelector{}))
+	index.DeleteCluster("deleted")
+
+	tests := []struct {
+		name        string
+		namespace   string
+		statefulSet string
+		want        []string
+	}{
+		{name: "labels and namespace selected", namespace: "prod", statefulSet: "db", want: []string{"all", "backup", "prod-backup"}},
+		{name: "namespace not selected", namespace: "dev", statefulSet: "db", want: []string{"all", "backup"}},
+		{name: "labels not selected", namespace: "prod", statefulSet: "web", want: []string{"all"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := index.ClusterPolicies(context.TODO(), tt.namespace, tt.statefulSet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, p := range got {
+				names = append(names, p.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("ClusterPolicies() got = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndex_Synced(t *testing.T) {
+	i := NewIndex(nil)
+	if err := i.Synced(nil); err != nil {
+		t.Errorf("Synced() without informers error = %v", err)
+	}
+	synced := false
+	i.synced = []func() bool{func() bool { return true }, func() bool { return synced }}
+	if err := i.Synced(nil); err == nil {
+		t.Errorf("Synced() expected error before the informers synced")
+	}
+	synced = true
+	if err := i.Synced(nil); err != nil {
+		t.Errorf("Synced() error = %v", err)
+	}
+}
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	s := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestIndex_Delivered(t *testing.T) {
+	cache := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(
+		newPolicy("default", "web", v1alpha1.PolicyTarget{Name: "web"}),
+		&v1alpha1.ClusterSpoditorPolicy{ObjectMeta: metav1.ObjectMeta{Name: "backup"}},
+	).Build()
+	i := NewIndex(nil)
+	synced := delivered(func() bool { return true }, func() bool {
+		return i.policiesDelivered(cache) && i.clusterPoliciesDelivered(cache)
+	})
+	if synced() {
+		t.Errorf("delivered() = true before the policies reached the index")
+	}
+	i.Set(newPolicy("default", "web", v1alpha1.PolicyTarget{Name: "web"}))
+	if synced() {
+		t.Errorf("delivered() = true before the cluster policies reached the index")
+	}
+	i.SetCluster(&v1alpha1.ClusterSpoditorPolicy{ObjectMeta: metav1.ObjectMeta{Name: "backup"}})
+	if !synced() {
+		t.Errorf("delivered() = false once the policies reached the index")
+	}
+	i.Delete(types.NamespacedName{Namespace: "default", Name: "web"})
+	if !synced() {
+		t.Errorf("delivered() = false after the initial delivery")
+	}
+	if delivered(func() bool { return false }, func() bool { return true })() {
+		t.Errorf("delivered() = true before the informer synced")
+	}
+}
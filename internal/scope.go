@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// Enabled is the label opting namespaces in, and pods out with value false, of the webhook configuration selectors
+	Enabled = "spoditor.io/enabled"
+)
+
+// DefaultExcludedNamespaces are never mutated, whatever their labels
+var DefaultExcludedNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+// Scope restricts the pods the webhook mutates, double-checking the selectors of the webhook configuration
+// so that a misconfigured selector can't make spoditor mutate system namespaces
+type Scope struct {
+	ExcludedNamespaces []string
+	// NamespaceSelector is required on the labels of the pod namespace, nil to include all the namespaces
+	NamespaceSelector labels.Selector
+	// ObjectSelector is required on the labels of the pod, nil to include all the pods
+	ObjectSelector labels.Selector
+	// Namespaces reads the namespaces when NamespaceSelector is set
+	Namespaces client.Reader
+}
+
+// Includes tells whether the pod of the namespace is in scope, with the reason when it isn't
+func (s *Scope) Includes(ctx context.Context, namespace string, podLabels map[string]string) (bool, string, error) {
+	for _, n := range s.ExcludedNamespaces {
+		if n == namespace {
+			return false, fmt.Sprintf("namespace %s is excluded", namespace), nil
+		}
+	}
+	if s.ObjectSelector != nil && !s.ObjectSelector.Matches(labels.Set(podLabels)) {
+		return false, fmt.Sprintf("pod labels don't match %s", s.ObjectSelector), nil
+	}
+	if s.NamespaceSelector == nil || s.NamespaceSelector.Empty() {
+		return true, "", nil
+	}
+	ns := &v1.Namespace{}
+	if err := s.Namespaces.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return false, "", fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+	}
+	if !s.NamespaceSelector.Matches(labels.Set(ns.Labels)) {
+		return false, fmt.Sprintf("namespace %s labels don't match %s", namespace, s.NamespaceSelector), nil
+	}
+	return true, "", nil
+}
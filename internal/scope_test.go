@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestScope_Includes(t *testing.T) {
+	namespaces := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "opted-in", Labels: map[string]string{Enabled: "true"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+	).Build()
+	scope := &Scope{
+		ExcludedNamespaces: DefaultExcludedNamespaces,
+		NamespaceSelector:  labels.SelectorFromSet(labels.Set{Enabled: "true"}),
+		ObjectSelector:     labels.Everything(),
+		Namespaces:         namespaces,
+	}
+	optOut, err := labels.Parse(Enabled + "!=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		scope     *Scope
+		namespace string
+		labels    map[string]string
+		want      bool
+		wantErr   bool
+	}{
+		{name: "opted in namespace", scope: scope, namespace: "opted-in", want: true},
+		{name: "namespace not opted in", scope: scope, namespace: "default", want: false},
+		{name: "unknown namespace", scope: scope, namespace: "unknown", want: false, wantErr: true},
+		{
+			name:      "excluded namespace even opted in",
+			scope:     &Scope{ExcludedNamespaces: []string{"opted-in"}, NamespaceSelector: scope.NamespaceSelector, Namespaces: namespaces},
+			namespace: "opted-in",
+			want:      false,
+		},
+		{name: "system namespace without selector", scope: &Scope{ExcludedNamespaces: DefaultExcludedNamespaces}, namespace: "kube-system", want: false},
+		{name: "all namespaces without selector", scope: &Scope{}, namespace: "default", want: true},
+		{
+			name:      "pod opted out",
+			scope:     &Scope{ObjectSelector: optOut},
+			namespace: "default",
+			labels:    map[string]string{Enabled: "false"},
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := tt.scope.Includes(context.TODO(), tt.namespace, tt.labels)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Includes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Includes() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
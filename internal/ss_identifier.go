@@ -2,11 +2,13 @@ package internal
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -38,3 +40,191 @@ var LabelSSPodIdentifier SSPodIdentifierFunc = func(accessor v1.ObjectMetaAccess
 	ordinal, _ := strconv.Atoi(l[i+1:])
 	return l[:i], ordinal, nil
 }
+
+const (
+	// PodIndexLabel is set on StatefulSet pods since Kubernetes 1.28
+	PodIndexLabel = "apps.kubernetes.io/pod-index"
+)
+
+// OwnerRule recognizes the pods of a StatefulSet-like controller, e.g. an OpenKruise Advanced StatefulSet
+type OwnerRule struct {
+	// Group and Kind of the controller ownerReference of the pods
+	Group string
+	Kind  string
+	// IndexLabel optionally holds the pod ordinal, which has to be consistent with the pod name
+	IndexLabel string
+	// NameRegex extracts the ordinal from the pod name, as its "ordinal" named group or its first group.
+	// When nil, the pod name has to be the owner name suffixed with -<ordinal>
+	NameRegex *regexp.Regexp
+}
+
+// StatefulSetOwnerRule recognizes the pods of apps/v1 StatefulSets
+var StatefulSetOwnerRule = OwnerRule{
+	Group:      "apps",
+	Kind:       "StatefulSet",
+	IndexLabel: PodIndexLabel,
+}
+
+// ParseOwnerRule parses <group>/<kind>[,label=<index label>][,name-regex=<regex>], the regex taking the rest of the string
+func ParseOwnerRule(s string) (OwnerRule, error) {
+	r := OwnerRule{}
+	gk := s
+	var opts string
+	if i := strings.Index(s, ","); i != -1 {
+		gk, opts = s[:i], s[i+1:]
+	}
+	i := strings.LastIndex(gk, "/")
+	if i == -1 || gk[i+1:] == "" {
+		return r, fmt.Errorf("invalid owner rule %q, expect <group>/<kind>", s)
+	}
+	r.Group, r.Kind = gk[:i], gk[i+1:]
+	for opts != "" {
+		var opt string
+		if strings.HasPrefix(opts, "name-regex=") {
+			opt, opts = opts, ""
+		} else if i := strings.Index(opts, ","); i != -1 {
+			opt, opts = opts[:i], opts[i+1:]
+		} else {
+			opt, opts = opts, ""
+		}
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return r, fmt.Errorf("invalid owner rule option %q", opt)
+		}
+		switch kv[0] {
+		case "label":
+			r.IndexLabel = kv[1]
+		case "name-regex":
+			re, err := regexp.Compile(kv[1])
+			if err != nil {
+				return r, fmt.Errorf("invalid owner rule name-regex: %v", err)
+			}
+			if re.NumSubexp() == 0 {
+				return r, fmt.Errorf("owner rule name-regex %q has no group capturing the ordinal", kv[1])
+			}
+			r.NameRegex = re
+		default:
+			return r, fmt.Errorf("unknown owner rule option %q", kv[0])
+		}
+	}
+	return r, nil
+}
+
+func (r OwnerRule) String() string {
+	s := r.Group + "/" + r.Kind
+	if r.IndexLabel != "" {
+		s += ",label=" + r.IndexLabel
+	}
+	if r.NameRegex != nil {
+		s += ",name-regex=" + r.NameRegex.String()
+	}
+	return s
+}
+
+// OwnerRules is a repeatable flag of owner rules
+type OwnerRules []OwnerRule
+
+func (r *OwnerRules) String() string {
+	var s []string
+	for _, o := range *r {
+		s = append(s, o.String())
+	}
+	return strings.Join(s, " ")
+}
+
+func (r *OwnerRules) Set(v string) error {
+	o, err := ParseOwnerRule(v)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, o)
+	return nil
+}
+
+func (r OwnerRule) matches(owner *v1.OwnerReference) bool {
+	gv, err := schema.ParseGroupVersion(owner.APIVersion)
+	return err == nil && gv.Group == r.Group && owner.Kind == r.Kind
+}
+
+func (r OwnerRule) ordinal(owner string, name string) (int, error) {
+	s := strings.TrimPrefix(name, owner+"-")
+	if r.NameRegex != nil {
+		m := r.NameRegex.FindStringSubmatch(name)
+		if m == nil {
+			return -1, fmt.Errorf("pod name %q doesn't match %q", name, r.NameRegex)
+		}
+		s = m[1]
+		if i := r.NameRegex.SubexpIndex("ordinal"); i != -1 {
+			s = m[i]
+		}
+	} else if !strings.HasPrefix(name, owner+"-") {
+		return -1, fmt.Errorf("pod name %q doesn't start with %s name %q", name, r.Kind, owner)
+	}
+	ordinal, err := strconv.Atoi(s)
+	if err != nil || ordinal < 0 {
+		return -1, fmt.Errorf("pod name %q doesn't end with an ordinal", name)
+	}
+	return ordinal, nil
+}
+
+// ErrNoController is returned by the owner identifiers for the pods without controller ownerReference
+var ErrNoController = errors.New("missing controller ownerReference")
+
+// NewOwnerSSPodIdentifier resolves the StatefulSet-like controller of the pod from its ownerReferences,
+// and the ordinal from the pod name, checked against the index label when present
+func NewOwnerSSPodIdentifier(rules ...OwnerRule) SSPodIdentifierFunc {
+	return func(accessor v1.ObjectMetaAccessor) (string, int, error) {
+		meta := accessor.GetObjectMeta()
+		owner := v1.GetControllerOf(meta)
+		if owner == nil {
+			podIdentifierLog.Info("controller ownerReference not found")
+			return "", -1, ErrNoController
+		}
+		for _, r := range rules {
+			if !r.matches(owner) {
+				continue
+			}
+			name := meta.GetName()
+			ordinal, err := r.ordinal(owner.Name, name)
+			if err != nil {
+				return "", -1, err
+			}
+			if l, ok := meta.GetLabels()[r.IndexLabel]; ok && r.IndexLabel != "" {
+				i, err := strconv.Atoi(l)
+				if err != nil || i != ordinal {
+					return "", -1, fmt.Errorf("label %s=%q doesn't match pod name %q", r.IndexLabel, l, name)
+				}
+			}
+			podIdentifierLog.Info("stateful pod owner", "kind", owner.Kind, "name", owner.Name, "ordinal", ordinal)
+			return owner.Name, ordinal, nil
+		}
+		podIdentifierLog.Info("pod not controlled by a statefulset", "apiVersion", owner.APIVersion, "kind", owner.Kind)
+		return "", -1, fmt.Errorf("controller %s %s is not a recognized statefulset", owner.APIVersion, owner.Kind)
+	}
+}
+
+// OwnerSSPodIdentifier identifies the pods controlled by apps/v1 StatefulSets
+var OwnerSSPodIdentifier = NewOwnerSSPodIdentifier(StatefulSetOwnerRule)
+
+// ChainSSPodIdentifier tries each identifier in order while they fail with ErrNoController, so that the pod of
+// another controller, or whose ownerReference contradicts its labels, isn't identified by the next identifiers
+func ChainSSPodIdentifier(identifiers ...SSPodIdentifier) SSPodIdentifierFunc {
+	return func(accessor v1.ObjectMetaAccessor) (string, int, error) {
+		var errs []string
+		for _, id := range identifiers {
+			ss, ordinal, err := id.Extract(accessor)
+			if err == nil {
+				return ss, ordinal, nil
+			}
+			errs = append(errs, err.Error())
+			if !errors.Is(err, ErrNoController) {
+				break
+			}
+		}
+		return "", -1, fmt.Errorf("not a statefulset pod: %s", strings.Join(errs, "; "))
+	}
+}
+
+// DefaultSSPodIdentifier prefers the ownerReferences of the pod, falling back to the pod-name label for the pods
+// without controller
+var DefaultSSPodIdentifier = ChainSSPodIdentifier(OwnerSSPodIdentifier, LabelSSPodIdentifier)
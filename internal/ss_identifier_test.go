@@ -71,3 +71,246 @@ func TestLabelSSPodIdentifier_Extract(t *testing.T) {
 		})
 	}
 }
+
+func TestOwnerSSPodIdentifier_Extract(t *testing.T) {
+	controller := true
+	owned := func(apiVersion, kind, owner, name string, labels map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: labels,
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: apiVersion, Kind: kind, Name: owner, Controller: &controller},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		accessor metav1.ObjectMetaAccessor
+		want     string
+		want1    int
+		wantErr  bool
+	}{
+		{
+			name:     "missing owner",
+			accessor: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0"}},
+			want1:    -1,
+			wantErr:  true,
+		},
+		{
+			name:     "owned by a replicaset",
+			accessor: owned("apps/v1", "ReplicaSet", "web", "web-0", nil),
+			want1:    -1,
+			wantErr:  true,
+		},
+		{
+			name:     "pod name doesn't match owner",
+			accessor: owned("apps/v1", "StatefulSet", "web", "db-0", nil),
+			want1:    -1,
+			wantErr:  true,
+		},
+		{
+			name:     "pod index label mismatch",
+			accessor: owned("apps/v1", "StatefulSet", "web", "web-1", map[string]string{PodIndexLabel: "2"}),
+			want1:    -1,
+			wantErr:  true,
+		},
+		{
+			name:     "success from pod name",
+			accessor: owned("apps/v1", "StatefulSet", "my-web", "my-web-12", nil),
+			want:     "my-web",
+			want1:    12,
+		},
+		{
+			name:     "success with pod index label",
+			accessor: owned("apps/v1", "StatefulSet", "web", "web-3", map[string]string{PodIndexLabel: "3"}),
+			want:     "web",
+			want1:    3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1, err := OwnerSSPodIdentifier.Extract(tt.accessor)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Extract() got = %v, want %v", got, tt.want)
+			}
+			if got1 != tt.want1 {
+				t.Errorf("Extract() got1 = %v, want %v", got1, tt.want1)
+			}
+		})
+	}
+}
+
+func TestDefaultSSPodIdentifier_Extract(t *testing.T) {
+	controller := true
+	tests := []struct {
+		name     string
+		accessor metav1.ObjectMetaAccessor
+		want     string
+		want1    int
+		wantErr  bool
+	}{
+		{
+			name:     "neither owner nor label",
+			accessor: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0"}},
+			want1:    -1,
+			wantErr:  true,
+		},
+		{
+			name: "fall back to label",
+			accessor: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"statefulset.kubernetes.io/pod-name": "dummy-ss-1"},
+				},
+			},
+			want:  "dummy-ss",
+			want1: 1,
+		},
+		{
+			name: "no fall back for another controller",
+			accessor: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "web-1",
+					Labels:          map[string]string{"statefulset.kubernetes.io/pod-name": "web-1"},
+					OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web", Controller: &controller}},
+				},
+			},
+			want1:   -1,
+			wantErr: true,
+		},
+		{
+			name: "no fall back for a mismatched index label",
+			accessor: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "web-1",
+					Labels:          map[string]string{"statefulset.kubernetes.io/pod-name": "web-1", PodIndexLabel: "2"},
+					OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "web", Controller: &controller}},
+				},
+			},
+			want1:   -1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1, err := DefaultSSPodIdentifier.Extract(tt.accessor)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Extract() got = %v, want %v", got, tt.want)
+			}
+			if got1 != tt.want1 {
+				t.Errorf("Extract() got1 = %v, want %v", got1, tt.want1)
+			}
+		})
+	}
+}
+
+func TestParseOwnerRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{name: "group and kind", s: "apps.kruise.io/StatefulSet", want: "apps.kruise.io/StatefulSet"},
+		{name: "core group", s: "/Member", want: "/Member"},
+		{
+			name: "all options",
+			s:    "example.com/Cluster,label=example.com/index,name-regex=^member-(?P<ordinal>\\d{1,3})$",
+			want: "example.com/Cluster,label=example.com/index,name-regex=^member-(?P<ordinal>\\d{1,3})$",
+		},
+		{name: "missing kind", s: "apps.kruise.io", wantErr: true},
+		{name: "unknown option", s: "apps.kruise.io/StatefulSet,foo=bar", wantErr: true},
+		{name: "regex without group", s: "example.com/Cluster,name-regex=member-\\d+", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOwnerRule(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseOwnerRule() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("ParseOwnerRule() got = %v, want %v", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOwnerSSPodIdentifier(t *testing.T) {
+	controller := true
+	owned := func(apiVersion, kind, owner, name string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: apiVersion, Kind: kind, Name: owner, Controller: &controller},
+				},
+			},
+		}
+	}
+	kruise, _ := ParseOwnerRule("apps.kruise.io/StatefulSet")
+	custom, _ := ParseOwnerRule("example.com/Cluster,name-regex=^(?P<cluster>.+)-member-(?P<ordinal>\\d+)$")
+	id := NewOwnerSSPodIdentifier(StatefulSetOwnerRule, kruise, custom)
+	tests := []struct {
+		name     string
+		accessor metav1.ObjectMetaAccessor
+		want     string
+		want1    int
+		wantErr  bool
+	}{
+		{
+			name:     "apps statefulset",
+			accessor: owned("apps/v1", "StatefulSet", "web", "web-0"),
+			want:     "web",
+			want1:    0,
+		},
+		{
+			name:     "kruise advanced statefulset",
+			accessor: owned("apps.kruise.io/v1beta1", "StatefulSet", "web", "web-4"),
+			want:     "web",
+			want1:    4,
+		},
+		{
+			name:     "custom operator with name regex",
+			accessor: owned("example.com/v1", "Cluster", "db", "db-member-2"),
+			want:     "db",
+			want1:    2,
+		},
+		{
+			name:     "custom operator name mismatch",
+			accessor: owned("example.com/v1", "Cluster", "db", "db-2"),
+			want1:    -1,
+			wantErr:  true,
+		},
+		{
+			name:     "unrecognized kind",
+			accessor: owned("example.com/v1", "Other", "db", "db-2"),
+			want1:    -1,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, got1, err := id.Extract(tt.accessor)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Extract() got = %v, want %v", got, tt.want)
+			}
+			if got1 != tt.want1 {
+				t.Errorf("Extract() got1 = %v, want %v", got1, tt.want1)
+			}
+		})
+	}
+}
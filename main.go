@@ -18,20 +18,34 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 
+	"github.com/spoditor/spoditor/api/v1alpha1"
 	"github.com/spoditor/spoditor/internal"
 	"github.com/spoditor/spoditor/internal/annotation"
+	"github.com/spoditor/spoditor/internal/annotation/external"
+	"github.com/spoditor/spoditor/internal/annotation/identity"
+	"github.com/spoditor/spoditor/internal/annotation/network"
+	"github.com/spoditor/spoditor/internal/annotation/scheduling"
+	"github.com/spoditor/spoditor/internal/annotation/script"
+	"github.com/spoditor/spoditor/internal/annotation/security"
 	"github.com/spoditor/spoditor/internal/annotation/volumes"
+	"github.com/spoditor/spoditor/internal/cli"
+	"github.com/spoditor/spoditor/internal/config"
+	"github.com/spoditor/spoditor/internal/policies"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	// +kubebuilder:scaffold:imports
@@ -44,19 +58,123 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 
 	// +kubebuilder:scaffold:scheme
 }
 
+// handlers are the built-in annotation handlers registered to the webhook and used by the offline commands,
+// escalationNamespaces being allowed to escalate privileges with securityContext overrides, serviceAccounts,
+// when set, validating the service accounts pods are assigned and statefulSets, when set, giving scripts the replicas
+func handlers(escalationNamespaces []string, serviceAccounts, statefulSets client.Reader) []annotation.Handler {
+	return []annotation.Handler{
+		&volumes.MountHandler{},
+		&volumes.ClaimHandler{},
+		&security.ContextHandler{EscalationNamespaces: escalationNamespaces},
+		&identity.ServiceAccountHandler{ServiceAccounts: serviceAccounts},
+		&scheduling.ZoneHandler{},
+		&network.PortHandler{},
+		&script.StarlarkHandler{StatefulSets: statefulSets},
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "render":
+			if err := cli.Render(os.Args[2:], handlers(nil, nil, nil), os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "lint":
+			if err := cli.Lint(os.Args[2:], handlers(nil, nil, nil), os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	var configFile string
 	var metricsAddr string
+	var webhookPort int
+	var certDir string
+	var leaderElectionID string
+	var annotationPrefixes string
+	var qualifierSeparator string
 	var enableLeaderElection bool
 	var probeAddr string
+	var onError string
+	var dryRun bool
+	var ownerRules internal.OwnerRules
+	var qualifierOrdinals string
+	var namespaceSelector string
+	var objectSelector string
+	var excludedNamespaces string
+	var externalHandlers external.Handlers
+	var enableHandlers string
+	var disableHandlers string
+	var restoreClaims bool
+	var escalationNamespaces string
+	var guardrailPermits string
+	flag.StringVar(&configFile, "config", "",
+		"The SpoditorConfig file to load the options from, command line flags overriding its values.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.IntVar(&webhookPort, "webhook-port", 9443, "The port the webhook server serves at.")
+	flag.StringVar(&certDir, "cert-dir", "",
+		"The directory of the tls.crt and tls.key of the webhook server, "+
+			"{TempDir}/k8s-webhook-server/serving-certs when empty.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "12517100.spoditor.io",
+		"The name of the resource leader election uses for holding the leader lock.")
+	flag.StringVar(&annotationPrefixes, "annotation-prefixes", annotation.Prefix,
+		"Comma separated prefixes of the annotation keys handled by spoditor, "+
+			"the first one taking precedence when the same annotation has several prefixes.")
+	flag.StringVar(&qualifierSeparator, "qualifier-separator", annotation.Separator,
+		"The separator between an annotation name and its qualifier, e.g. _ in spoditor.io/mount-volume_0-2. "+
+			"When empty, only the alternative syntax, e.g. spoditor.io/mount-volume.ordinals-0-2, qualifies annotations.")
+	flag.StringVar(&onError, "on-error", string(internal.FailurePolicyAllow),
+		"How to answer when a StatefulSet pod fails to be mutated, either allow or deny. "+
+			"A StatefulSet can override it with the spoditor.io/on-error annotation.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Only report the mutations as pod annotation, event and admission warning without applying them. "+
+			"A StatefulSet can override it with the spoditor.io/dry-run annotation.")
+	flag.StringVar(&qualifierOrdinals, "qualifier-ordinals", string(internal.OrdinalModeAbsolute),
+		"Ordinal qualifiers are evaluated against, either absolute or logical, relative to the StatefulSet spec.ordinals.start. "+
+			"A StatefulSet can override it with the spoditor.io/qualifier-ordinals annotation.")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "",
+		"Label selector the namespace of a pod must match to be mutated, e.g. spoditor.io/enabled=true. "+
+			"It double-checks the namespaceSelector of the webhook configuration.")
+	flag.StringVar(&objectSelector, "object-selector", "",
+		"Label selector a pod must match to be mutated, e.g. spoditor.io/enabled!=false. "+
+			"It double-checks the objectSelector of the webhook configuration.")
+	flag.StringVar(&excludedNamespaces, "excluded-namespaces", strings.Join(internal.DefaultExcludedNamespaces, ","),
+		"Comma separated namespaces whose pods are never mutated.")
+	flag.Var(&ownerRules, "owner-rule",
+		"Recognize the pods of a StatefulSet-like controller besides apps/v1 StatefulSets, "+
+			"as <group>/<kind>[,label=<index label>][,name-regex=<regex capturing the ordinal>]. Can be repeated.")
+	flag.Var(&externalHandlers, "external-handler",
+		"Delegate the spoditor.io/<annotation name> annotations to an HTTP service, "+
+			"as <annotation name>=<url>[,timeout=<duration>][,priority=<priority>][,dependsOn=<annotation name>]..., "+
+			"applied after the handlers it depends on, then by priority, 100 by default, lower first. Can be repeated.")
+	flag.StringVar(&enableHandlers, "enable-handlers", "",
+		"Comma separated annotation names of the only handlers to enable, all the registered handlers when empty.")
+	flag.StringVar(&disableHandlers, "disable-handlers", "",
+		"Comma separated annotation names of the handlers to disable.")
+	flag.BoolVar(&restoreClaims, "restore-claims", false,
+		"Run the controller and the webhook restoring the claims of StatefulSet pods from the snapshots or clones "+
+			"of their spoditor.io/restore-claims annotations before the pods are created.")
+	flag.StringVar(&escalationNamespaces, "escalation-namespaces", "",
+		"Comma separated namespaces whose pods can escalate privileges with spoditor.io/security-context annotations, "+
+			"e.g. privileged containers or root users, * for all the namespaces.")
+	flag.StringVar(&guardrailPermits, "guardrail-permits", "",
+		"Comma separated mutations spoditor may introduce into pods, among host-path, privileged, host-namespaces "+
+			"and capability=<name>, capability=* for all the capabilities. The others fail as per the on-error policy. "+
+			"The escalation namespaces are exempt.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,14 +183,68 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	var spoditorConfig *config.SpoditorConfig
+	if configFile != "" {
+		var err error
+		if spoditorConfig, err = config.Load(configFile); err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+		if err := config.SetFlags(flag.CommandLine, spoditorConfig); err != nil {
+			setupLog.Error(err, "invalid config file")
+			os.Exit(1)
+		}
+	}
+
+	failurePolicy, err := internal.ParseFailurePolicy(onError)
+	if err != nil {
+		setupLog.Error(err, "invalid on-error flag")
+		os.Exit(1)
+	}
+	ordinalMode, err := internal.ParseOrdinalMode(qualifierOrdinals)
+	if err != nil {
+		setupLog.Error(err, "invalid qualifier-ordinals flag")
+		os.Exit(1)
+	}
+	nsSelector, err := labels.Parse(namespaceSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid namespace-selector flag")
+		os.Exit(1)
+	}
+	podSelector, err := labels.Parse(objectSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid object-selector flag")
+		os.Exit(1)
+	}
+	guardrails, err := internal.ParseGuardrails(guardrailPermits)
+	if err != nil {
+		setupLog.Error(err, "invalid guardrail-permits flag")
+		os.Exit(1)
+	}
+	guardrails.ExemptNamespaces = splitList(escalationNamespaces)
+	syntax := annotation.Syntax{Prefixes: splitList(annotationPrefixes), Separator: qualifierSeparator}
+	if err := syntax.Validate(); err != nil {
+		setupLog.Error(err, "invalid annotation-prefixes or qualifier-separator flag")
+		os.Exit(1)
+	}
+
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
+		Port:                   webhookPort,
+		CertDir:                certDir,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "12517100.spoditor.io",
-	})
+		LeaderElectionID:       leaderElectionID,
+	}
+	if spoditorConfig != nil {
+		// the options of the config file without flag, e.g. syncPeriod or leaderElection.leaseDuration
+		if options, err = options.AndFrom(spoditorConfig.WithoutFlags()); err != nil {
+			setupLog.Error(err, "invalid config file")
+			os.Exit(1)
+		}
+	}
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
@@ -80,13 +252,83 @@ func main() {
 
 	// +kubebuilder:scaffold:builder
 
+	registry := annotation.NewRegistry()
+	for _, h := range handlers(splitList(escalationNamespaces), mgr.GetAPIReader(), mgr.GetClient()) {
+		if err := registry.Register(h); err != nil {
+			setupLog.Error(err, "unable to register handler")
+			os.Exit(1)
+		}
+	}
+	for _, h := range externalHandlers {
+		setupLog.Info("register external handler", "annotation", h.AnnotationName, "url", h.URL)
+		if err := registry.Register(h); err != nil {
+			setupLog.Error(err, "unable to register external handler")
+			os.Exit(1)
+		}
+	}
+	if err := registry.Configure(splitList(enableHandlers), splitList(disableHandlers)); err != nil {
+		setupLog.Error(err, "invalid enable-handlers or disable-handlers flag")
+		os.Exit(1)
+	}
+	enabledHandlers, err := registry.Handlers()
+	if err != nil {
+		setupLog.Error(err, "invalid handler registry")
+		os.Exit(1)
+	}
+
+	policyIndex := policies.NewIndex(mgr.GetClient())
+	if err := policyIndex.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up policy index")
+		os.Exit(1)
+	}
+
+	if restoreClaims {
+		claimRestorer := &internal.ClaimRestorer{
+			Client:            mgr.GetClient(),
+			Collector:         annotation.NewCollector(syntax),
+			QualifierOrdinals: ordinalMode,
+			OrdinalsStart:     internal.NewStatefulSetOrdinalsStart(mgr.GetCache()),
+			Recorder:          mgr.GetEventRecorderFor("spoditor"),
+		}
+		if err := claimRestorer.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up claim restorer")
+			os.Exit(1)
+		}
+		claimRestorer.SetupWebhookWithManager(mgr)
+	} else {
+		internal.SetupAllowingClaimWebhookWithManager(mgr)
+	}
+
 	podArgumentor := internal.PodArgumentor{
-		SSPodId:   internal.LabelSSPodIdentifier,
-		Collector: annotation.Collector,
+		SSPodId: internal.ChainSSPodIdentifier(
+			internal.NewOwnerSSPodIdentifier(append([]internal.OwnerRule{internal.StatefulSetOwnerRule}, ownerRules...)...),
+			internal.LabelSSPodIdentifier,
+		),
+		Collector:         annotation.NewCollector(syntax),
+		Syntax:            syntax,
+		FailurePolicy:     failurePolicy,
+		DryRun:            dryRun,
+		Recorder:          mgr.GetEventRecorderFor("spoditor"),
+		QualifierOrdinals: ordinalMode,
+		OrdinalsStart:     internal.NewStatefulSetOrdinalsStart(mgr.GetCache()),
+		Scope: &internal.Scope{
+			ExcludedNamespaces: splitList(excludedNamespaces),
+			NamespaceSelector:  nsSelector,
+			ObjectSelector:     podSelector,
+			Namespaces:         mgr.GetClient(),
+		},
+		Policies:   policyIndex,
+		Guardrails: guardrails,
+	}
+	for _, h := range enabledHandlers {
+		podArgumentor.Register(h)
 	}
-	podArgumentor.Register(&volumes.MountHandler{})
 	podArgumentor.SetupWebhookWithManager(mgr)
 
+	if err := mgr.AddMetricsExtraHandler("/debug/handlers", registry); err != nil {
+		setupLog.Error(err, "unable to set up handlers debug endpoint")
+		os.Exit(1)
+	}
 	if err := mgr.AddHealthzCheck("health", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -95,6 +337,10 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("policies", policyIndex.Synced); err != nil {
+		setupLog.Error(err, "unable to set up policies ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -102,3 +348,13 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}